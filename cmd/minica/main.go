@@ -0,0 +1,234 @@
+// Command minica creates the CAs and issues the certs for the tests and the development
+// without openssl (see the tls/minica package).
+//
+//	minica ca [-out dir] [-ca file -ca-key file] [flags] -name name
+//	minica cert [-out dir] (-ca file [-ca-key file] | -self-signed) [flags] -name name
+//
+// The cert, the private key and the SHA-1 fingerprint (as openssl prints it)
+// are written to dir/name.crt, dir/name.key and dir/name.crt.sha1.
+// "ca" creates the root CA, or the intermediate CA if -ca is set.
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gebv/go-lib/tls/minica"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  minica ca [flags] -name name      create the root (or, with -ca, the intermediate) CA")
+	fmt.Fprintln(w, "  minica cert [flags] -name name    issue the cert by the CA (or the self-signed cert)")
+	fmt.Fprintln(w, "Run minica <command> -h for the flags.")
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+	if cmd != "ca" && cmd != "cert" {
+		if cmd != "-h" && cmd != "-help" && cmd != "help" {
+			fmt.Fprintf(stderr, "unknown command %q\n", cmd)
+		}
+		usage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("minica "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		name, out, caFile, caKeyFile  string
+		subject, hosts, keyType, ekus string
+		days                          int
+		backdate                      time.Duration
+		selfSigned, client, csr       bool
+	)
+	fs.StringVar(&name, "name", "", "base name of the files (required)")
+	fs.StringVar(&out, "out", ".", "directory of the files")
+	fs.StringVar(&caFile, "ca", "", "cert file of the issuing CA")
+	fs.StringVar(&caKeyFile, "ca-key", "", "private key file of the issuing CA, the -ca file with .key extension by default")
+	fs.StringVar(&subject, "subject", "", `subject in the openssl form, e.g. "/C=US/O=Example/CN=localhost"`)
+	fs.StringVar(&keyType, "key-type", string(minica.ECDSAP256), "key type: "+keyTypes())
+	fs.DurationVar(&backdate, "backdate", 0, "start the validity the duration before now")
+	defaultDays := 365
+	if cmd == "ca" {
+		defaultDays = 3650
+	}
+	fs.IntVar(&days, "days", defaultDays, "validity in days from the start, 0 for the already expired cert")
+	if cmd == "cert" {
+		fs.StringVar(&hosts, "hosts", "", "comma separated SANs: DNS names, IP addresses, emails and URIs")
+		fs.BoolVar(&selfSigned, "self-signed", false, "create the self-signed cert, -ca is not used")
+		fs.BoolVar(&client, "client", false, "issue the client cert (the client auth usage)")
+		fs.StringVar(&ekus, "eku", "", "comma separated extended key usages: "+strings.Join(extKeyUsageNames(), ", ")+
+			" (server by default, client with -client)")
+		fs.BoolVar(&csr, "csr", false, "write the certificate request to name.csr too")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if name == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	req, err := request(subject, hosts, keyType, ekus, days, backdate)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	var issuer *minica.Cert
+	if caFile != "" && !selfSigned {
+		if caKeyFile == "" {
+			caKeyFile = strings.TrimSuffix(caFile, filepath.Ext(caFile)) + ".key"
+		}
+		if issuer, err = minica.Load(caFile, caKeyFile); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	} else if cmd == "cert" && !selfSigned {
+		fmt.Fprintln(stderr, "-ca or -self-signed is required")
+		return 2
+	}
+
+	var cert *minica.Cert
+	switch {
+	case cmd == "ca" && issuer == nil:
+		cert, err = minica.NewRoot(req)
+	case cmd == "ca":
+		cert, err = issuer.NewIntermediate(req)
+	case selfSigned:
+		cert, err = minica.SelfSigned(req)
+	case client:
+		cert, err = issuer.IssueClient(req)
+	default:
+		cert, err = issuer.IssueServer(req)
+	}
+	if err == nil {
+		err = cert.WriteFiles(out, name)
+	}
+	if err == nil && csr {
+		err = cert.WriteCSR(out, name)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	base := filepath.Join(out, name)
+	fmt.Fprintf(stdout, "%s: %s (valid %s - %s)\n", base+".crt", cert.Cert.Subject, cert.Cert.NotBefore.Format(time.RFC3339), cert.Cert.NotAfter.Format(time.RFC3339))
+	fmt.Fprintln(stdout, base+".key")
+	fmt.Fprintf(stdout, "%s: %s\n", base+".crt.sha1", cert.Fingerprint())
+	if csr {
+		fmt.Fprintln(stdout, base+".csr")
+	}
+	return 0
+}
+
+// request returns the request by the flags.
+func request(subject, hosts, keyType, ekus string, days int, backdate time.Duration) (minica.Request, error) {
+	var req minica.Request
+	var err error
+	if req.Subject, err = parseSubject(subject); err != nil {
+		return req, err
+	}
+	if hosts != "" {
+		req.Hosts = strings.Split(hosts, ",")
+	}
+	if req.KeyType, err = minica.ParseKeyType(keyType); err != nil {
+		return req, err
+	}
+	if ekus != "" {
+		for _, name := range strings.Split(ekus, ",") {
+			eku, ok := extKeyUsages[strings.TrimSpace(name)]
+			if !ok {
+				return req, errors.Errorf("unknown extended key usage %q", name)
+			}
+			req.ExtKeyUsage = append(req.ExtKeyUsage, eku)
+		}
+	}
+	if days < 0 {
+		return req, errors.New("negative -days")
+	}
+	req.NotBefore = time.Now().Add(-backdate)
+	req.NotAfter = req.NotBefore.Add(time.Duration(days) * 24 * time.Hour)
+	return req, nil
+}
+
+// parseSubject parses the subject in the openssl form "/C=US/O=Example/CN=localhost".
+func parseSubject(s string) (pkix.Name, error) {
+	var name pkix.Name
+	if s == "" {
+		return name, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return name, errors.Errorf("invalid subject %q: should start with /", s)
+	}
+	for _, part := range strings.Split(s[1:], "/") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return name, errors.Errorf("invalid subject %q: %q is not key=value", s, part)
+		}
+		key, value := part[:i], part[i+1:]
+		switch strings.ToUpper(key) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "STREET":
+			name.StreetAddress = append(name.StreetAddress, value)
+		case "POSTALCODE":
+			name.PostalCode = append(name.PostalCode, value)
+		case "SERIALNUMBER":
+			name.SerialNumber = value
+		default:
+			return name, errors.Errorf("invalid subject %q: unknown attribute %q", s, key)
+		}
+	}
+	return name, nil
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":          x509.ExtKeyUsageAny,
+	"server":       x509.ExtKeyUsageServerAuth,
+	"client":       x509.ExtKeyUsageClientAuth,
+	"code-signing": x509.ExtKeyUsageCodeSigning,
+	"email":        x509.ExtKeyUsageEmailProtection,
+	"timestamping": x509.ExtKeyUsageTimeStamping,
+	"ocsp-signing": x509.ExtKeyUsageOCSPSigning,
+}
+
+func extKeyUsageNames() []string {
+	return []string{"server", "client", "code-signing", "email", "timestamping", "ocsp-signing", "any"}
+}
+
+func keyTypes() string {
+	names := make([]string, len(minica.KeyTypes))
+	for i, kt := range minica.KeyTypes {
+		names[i] = string(kt)
+	}
+	return strings.Join(names, ", ")
+}
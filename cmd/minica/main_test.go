@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gebv/go-lib/tls/minica"
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	minicaRun := func(t *testing.T, args ...string) string {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		require.Equal(t, 0, run(append(args, "-out", dir), &stdout, &stderr), stderr.String())
+		return stdout.String()
+	}
+	load := func(t *testing.T, name string) *minica.Cert {
+		t.Helper()
+		cert, err := minica.Load(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"))
+		require.NoError(t, err)
+		return cert
+	}
+
+	minicaRun(t, "ca", "-name", "root", "-subject", "/C=US/O=Example/CN=Example-Root-CA", "-key-type", "rsa-2048")
+	minicaRun(t, "ca", "-name", "intermediate", "-ca", filepath.Join(dir, "root.crt"))
+	root, intermediate := load(t, "root"), load(t, "intermediate")
+	assert.Equal(t, "CN=Example-Root-CA,O=Example,C=US", root.Cert.Subject.String())
+	assert.True(t, intermediate.Cert.IsCA)
+	assert.Equal(t, root.Cert.Subject.String(), intermediate.Cert.Issuer.String())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate.Cert)
+
+	t.Run("server", func(t *testing.T) {
+		out := minicaRun(t, "cert", "-name", "localhost-ok", "-ca", filepath.Join(dir, "intermediate.crt"), "-hosts", "localhost,127.0.0.1", "-csr")
+		leaf := load(t, "localhost-ok")
+		_, err := leaf.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "127.0.0.1"})
+		assert.NoError(t, err)
+		assert.Contains(t, out, leaf.Fingerprint())
+
+		fingerprint, err := verify.FingerprintSHA1FromFile(filepath.Join(dir, "localhost-ok.crt.sha1"))
+		require.NoError(t, err)
+		assert.Equal(t, verify.CertFingerprints(leaf.Cert).SHA1, fingerprint)
+		_, err = verify.PinFromFile(filepath.Join(dir, "localhost-ok.csr"))
+		assert.NoError(t, err)
+	})
+	t.Run("expired", func(t *testing.T) {
+		minicaRun(t, "cert", "-name", "localhost-expired", "-ca", filepath.Join(dir, "root.crt"), "-hosts", "localhost", "-backdate", "48h", "-days", "1")
+		leaf := load(t, "localhost-expired")
+		assert.True(t, leaf.Cert.NotAfter.Before(time.Now()))
+	})
+	t.Run("client", func(t *testing.T) {
+		minicaRun(t, "cert", "-name", "client", "-ca", filepath.Join(dir, "root.crt"), "-client", "-subject", "/CN=client")
+		_, err := load(t, "client").Cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		assert.NoError(t, err)
+	})
+	t.Run("selfSigned", func(t *testing.T) {
+		minicaRun(t, "cert", "-name", "simple", "-self-signed", "-hosts", "localhost", "-key-type", "ed25519", "-eku", "server,client")
+		leaf := load(t, "simple")
+		assert.Equal(t, leaf.Cert.Subject.String(), leaf.Cert.Issuer.String())
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, leaf.Cert.ExtKeyUsage)
+	})
+	t.Run("usage", func(t *testing.T) {
+		for _, args := range [][]string{
+			nil,
+			{"sign"},
+			{"ca"},
+			{"cert", "-name", "x"},
+			{"ca", "-name", "x", "-key-type", "dsa"},
+			{"ca", "-name", "x", "-subject", "CN=x"},
+			{"cert", "-name", "x", "-self-signed", "-eku", "server,unknown"},
+		} {
+			var stdout, stderr bytes.Buffer
+			assert.Equal(t, 2, run(append(args, "-out", dir), &stdout, &stderr), "%q", args)
+		}
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 1, run([]string{"cert", "-name", "x", "-ca", filepath.Join(dir, "missing.crt"), "-out", dir}, &stdout, &stderr))
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/pkg/errors"
+)
+
+// policy is what the server is checked against.
+type policy struct {
+	roots      *x509.CertPool
+	pin        string
+	skipChain  bool
+	serverName string
+	timeout    time.Duration
+}
+
+const (
+	statusPass = "PASS"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+)
+
+type step struct {
+	name     string
+	status   string
+	detail   string
+	exitCode int
+}
+
+// diagnose runs the steps, the steps after the failed connect or handshake are not run.
+func diagnose(addr string, p policy) []step {
+	serverName := p.serverName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(addr)
+	}
+
+	var steps []step
+	pass := func(name, detail string) {
+		steps = append(steps, step{name: name, status: statusPass, detail: detail})
+	}
+	fail := func(name string, code int, detail string) {
+		steps = append(steps, step{name: name, status: statusFail, detail: detail, exitCode: code})
+	}
+	skip := func(name, detail string) {
+		steps = append(steps, step{name: name, status: statusSkip, detail: detail})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	started := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		fail("tcp connect", exitConnect, err.Error())
+		return steps
+	}
+	conn.Close()
+	pass("tcp connect", fmt.Sprintf("connected to %s in %s", addr, time.Since(started).Round(time.Millisecond)))
+
+	// the chain is taken without the verification, the verifier checks it below step by step
+	report, err := verify.Inspect(ctx, addr, verify.SkipTLSVerify(), verify.DNSName(serverName))
+	if err != nil {
+		fail("handshake", exitHandshake, errors.Cause(err).Error())
+		return steps
+	}
+	alpn := report.ALPN
+	if alpn == "" {
+		alpn = "none"
+	}
+	pass("handshake", fmt.Sprintf("%s, %s, ALPN %s, %d certificate(s), leaf %s",
+		report.Version, report.CipherSuite, alpn, len(report.Certificates), report.Certificates[0].Subject))
+
+	rawCerts := make([][]byte, len(report.Certificates))
+	for i, cert := range report.Certificates {
+		rawCerts[i] = cert.Certificate.Raw
+	}
+	leaf := report.Certificates[0].Certificate
+
+	// the validity period is checked in any chain mode, e.g. for the pinned self-signed certs
+	expiryErr := check(rawCerts, verify.SkipTLSVerify(), verify.Checks(verify.NotExpired()))
+
+	if p.skipChain {
+		skip("chain", "not checked: the chain verification is skipped by the policy")
+		skip("hostname", "not checked: the chain verification is skipped by the policy")
+	} else {
+		var verified *verify.Result
+		chainErr := check(rawCerts,
+			verify.RootCAs(p.roots),
+			verify.Observer(func(e verify.Event) { verified = e.Result }),
+		)
+		hostErr := check(rawCerts, verify.RootCAs(p.roots), verify.DNSName(serverName))
+		expired := errors.Is(chainErr, verify.ErrCertExpired)
+
+		switch {
+		case chainErr == nil && verified != nil && len(verified.VerifiedChains) > 0:
+			chain := verified.VerifiedChains[0]
+			pass("chain", fmt.Sprintf("verified up to the root %s", chain[len(chain)-1].Subject))
+		case chainErr == nil:
+			pass("chain", "verified")
+		case expired:
+			skip("chain", "not checked: a certificate is expired or not valid yet")
+		default:
+			fail("chain", exitChain, verify.Explain(chainErr))
+		}
+
+		var hostnameErr x509.HostnameError
+		switch {
+		case hostErr == nil:
+			pass("hostname", fmt.Sprintf("the certificate is valid for %s", serverName))
+		case errors.As(hostErr, &hostnameErr):
+			fail("hostname", exitHostname, verify.Explain(hostErr))
+		case expired:
+			skip("hostname", "not checked: a certificate is expired or not valid yet")
+		default:
+			skip("hostname", "not checked: the chain is not valid")
+		}
+	}
+
+	if expiryErr != nil {
+		fail("expiry", exitExpired, verify.Explain(expiryErr))
+	} else {
+		pass("expiry", fmt.Sprintf("the certificates are valid, the leaf is valid until %s", leaf.NotAfter.UTC().Format(time.RFC3339)))
+	}
+
+	if p.pin == "" {
+		skip("pin", "not checked: no pin in the policy")
+	} else if err := check(rawCerts, verify.SkipTLSVerify(), verify.DNSName(serverName), pinOption(p.pin)); err != nil {
+		fail("pin", exitPin, verify.Explain(err))
+	} else {
+		pass("pin", fmt.Sprintf("the leaf matches the pin %s", p.pin))
+	}
+
+	detail := "not checked: the verifier does not check the revocation"
+	if len(leaf.OCSPServer) > 0 {
+		detail += fmt.Sprintf(", OCSP responder %s", strings.Join(leaf.OCSPServer, ", "))
+	}
+	if len(leaf.CRLDistributionPoints) > 0 {
+		detail += fmt.Sprintf(", CRL %s", strings.Join(leaf.CRLDistributionPoints, ", "))
+	}
+	skip("revocation", detail)
+	return steps
+}
+
+// check verifies the presented chain by the options, the invalid options are the error.
+func check(rawCerts [][]byte, opts ...verify.Option) error {
+	v, err := verify.NewTLSVerifyPeerCertificate(opts...)
+	if err != nil {
+		return err
+	}
+	return v.Option()(rawCerts, nil)
+}
+
+// pinOption returns the option of the SHA-256 SPKI pin or of the SHA-1 fingerprint of the leaf.
+func pinOption(pin string) verify.Option {
+	if isSPKIPin(pin) {
+		return verify.PinSHA256(pin)
+	}
+	return verify.FingerprintSHA1(pin)
+}
+
+// isSPKIPin reports whether the pin is in the form "sha256/<base64>" of the pin of the public key.
+func isSPKIPin(pin string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(pin)), "sha256/")
+}
@@ -0,0 +1,122 @@
+// Command tlsdoctor diagnoses the TLS connection to the server step by step:
+// TCP connect, handshake, chain, hostname, expiry, pin and revocation.
+//
+//	tlsdoctor [-ca file] [-pin sha1 | -pin sha256/base64 | -pin-file file] [-skip-chain] [-servername name] host:port
+//
+// The checks are done by the tls/verify verifier. The exit code is the code
+// of the first failed step (see the exit* constants), 0 if all steps pass.
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/pkg/errors"
+)
+
+// The exit codes.
+const (
+	exitOK        = 0
+	exitUsage     = 2
+	exitConnect   = 3
+	exitHandshake = 4
+	exitChain     = 5
+	exitHostname  = 6
+	exitExpired   = 7
+	exitPin       = 8
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tlsdoctor", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tlsdoctor [flags] host:port")
+		fs.PrintDefaults()
+		fmt.Fprintln(stderr, "Exit codes: 3 connect, 4 handshake, 5 chain, 6 hostname, 7 expiry, 8 pin.")
+	}
+	var (
+		caFile  string
+		pinFile string
+		p       policy
+	)
+	fs.StringVar(&caFile, "ca", "", "PEM file of the trusted roots, the system roots by default")
+	fs.StringVar(&p.pin, "pin", "", "expected SHA-1 fingerprint of the leaf cert or SHA-256 pin of its public key (sha256/<base64>, not the SHA-256 of the cert)")
+	fs.StringVar(&pinFile, "pin-file", "", "cert file or file with the expected SHA-1 fingerprint (e.g. the openssl output)")
+	fs.BoolVar(&p.skipChain, "skip-chain", false, "do not verify the chain and the hostname (for the pinned self-signed certs)")
+	fs.StringVar(&p.serverName, "servername", "", "server name to send and check, the host of the address by default")
+	fs.DurationVar(&p.timeout, "timeout", 10*time.Second, "timeout of the connect and the handshake")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if caFile != "" {
+		roots, err := loadRoots(caFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		p.roots = roots
+	}
+	if pinFile != "" {
+		pin, err := verify.FingerprintSHA1FromFile(pinFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		p.pin = pin
+	}
+	if p.pin != "" {
+		if f, err := verify.ParseFingerprint(p.pin); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		} else if f.Algorithm != "sha1" && f.Algorithm != "sha256" {
+			fmt.Fprintf(stderr, "pin %q is not SHA-1 or SHA-256\n", p.pin)
+			return exitUsage
+		} else if f.Algorithm == "sha256" && !isSPKIPin(p.pin) {
+			// the SHA-256 of the cert is not accepted as the pin
+			fmt.Fprintf(stderr, "pin %q is ambiguous: the SHA-256 pin of the public key is sha256/<base64>\n", p.pin)
+			return exitUsage
+		}
+	}
+
+	addr := fs.Arg(0)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+
+	code := exitOK
+	for _, s := range diagnose(addr, p) {
+		fmt.Fprintf(stdout, "[%s] %-12s %s\n", s.status, s.name, s.detail)
+		if s.status == statusFail && code == exitOK {
+			code = s.exitCode
+		}
+	}
+	return code
+}
+
+func loadRoots(file string) (*x509.CertPool, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read CA file")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(dat) {
+		return nil, errors.Errorf("no certificates in CA file %q", file)
+	}
+	return roots, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve accepts the TLS connections with the self-signed cert for localhost and 127.0.0.1.
+// It returns the address and the PEM file of the cert.
+func serve(t *testing.T, notBefore, notAfter time.Time) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return lis.Addr().String(), caFile, cert
+}
+
+func TestRun(t *testing.T) {
+	addr, caFile, cert := serve(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	expiredAddr, expiredCAFile, _ := serve(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	sum := sha1.Sum(cert.Raw)
+	pin := hex.EncodeToString(sum[:])
+	spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	spkiPin := "sha256/" + base64.StdEncoding.EncodeToString(spkiSum[:])
+	pinFile := filepath.Join(t.TempDir(), "cert.crt.sha1")
+	require.NoError(t, ioutil.WriteFile(pinFile, []byte("SHA1 Fingerprint="+strings.ToUpper(pin)+"\n"), 0600))
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer plain.Close()
+	go func() {
+		for {
+			conn, err := plain.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name  string
+		args  []string
+		code  int
+		steps []string
+	}{
+		{
+			"ok",
+			[]string{"-ca", caFile, "-pin", pin, addr},
+			exitOK,
+			[]string{"[PASS] tcp connect", "[PASS] handshake", "[PASS] chain", "[PASS] hostname", "[PASS] expiry", "[PASS] pin", "[SKIP] revocation"},
+		},
+		{
+			"pinFile",
+			[]string{"-skip-chain", "-pin-file", pinFile, addr},
+			exitOK,
+			[]string{"[SKIP] chain", "[SKIP] hostname", "[PASS] expiry", "[PASS] pin"},
+		},
+		{
+			"pinSHA256",
+			[]string{"-skip-chain", "-pin", spkiPin, addr},
+			exitOK,
+			[]string{"[PASS] pin          the leaf matches the pin " + spkiPin},
+		},
+		{
+			"pinSHA256Mismatch",
+			[]string{"-skip-chain", "-pin", "sha256/q4PO2G2cbkZhZ82+JgmRUyGMoAeozA+BSXVXQWB8XWQ=", addr},
+			exitPin,
+			[]string{"[FAIL] pin          Certificate for 127.0.0.1 does not match the pinned public key"},
+		},
+		{
+			"skipChainExpired",
+			[]string{"-skip-chain", expiredAddr},
+			exitExpired,
+			[]string{"[SKIP] chain", "[SKIP] hostname", "[FAIL] expiry       Certificate for localhost expired 1 hour ago"},
+		},
+		{
+			"unknownAuthority",
+			[]string{addr},
+			exitChain,
+			[]string{"[FAIL] chain", "[SKIP] hostname", "[PASS] expiry", "[SKIP] pin"},
+		},
+		{
+			"hostname",
+			[]string{"-ca", caFile, "-servername", "example.org", addr},
+			exitHostname,
+			[]string{"[PASS] chain", "[FAIL] hostname     Certificate is not valid for example.org, it is valid for localhost, 127.0.0.1."},
+		},
+		{
+			"expired",
+			[]string{"-ca", expiredCAFile, expiredAddr},
+			exitExpired,
+			[]string{"[SKIP] chain", "[SKIP] hostname", "[FAIL] expiry       Certificate for localhost expired 1 hour ago"},
+		},
+		{
+			"pin",
+			[]string{"-ca", caFile, "-pin", "81f344a7686a80b4c5293e8fdc0b0160c82c06a8", addr},
+			exitPin,
+			[]string{"[PASS] chain", "[FAIL] pin          Certificate for 127.0.0.1 does not match the pinned fingerprint"},
+		},
+		{
+			"firstFailure",
+			[]string{"-pin", "81f344a7686a80b4c5293e8fdc0b0160c82c06a8", addr},
+			exitChain,
+			[]string{"[FAIL] chain", "[FAIL] pin"},
+		},
+		{"connect", []string{closedAddr}, exitConnect, []string{"[FAIL] tcp connect"}},
+		{"handshake", []string{plain.Addr().String()}, exitHandshake, []string{"[PASS] tcp connect", "[FAIL] handshake"}},
+		{"usage", nil, exitUsage, nil},
+		{"badCA", []string{"-ca", pinFile, addr}, exitUsage, nil},
+		{"badPin", []string{"-pin", "abc", addr}, exitUsage, nil},
+		{"badPinAlgorithm", []string{"-pin", "sha512/" + strings.Repeat("A", 86) + "==", addr}, exitUsage, nil},
+		{"certSHA256", []string{"-pin", strings.Repeat("ab", 32), addr}, exitUsage, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			assert.Equal(t, tt.code, run(tt.args, &stdout, &stderr), stdout.String()+stderr.String())
+			for _, want := range tt.steps {
+				assert.Contains(t, stdout.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gebv/go-lib/tls/verify"
+)
+
+// certFingerprints are the fingerprints of the cert in all printed formats.
+type certFingerprints struct {
+	Source  string `json:"source"`
+	Index   int    `json:"index"`
+	Subject string `json:"subject"`
+	Issuer  string `json:"issuer"`
+
+	// SHA1 is the fingerprint of the cert. The SHA-256 of the cert is not printed:
+	// it is not accepted by the options, the SHA-256 pins are of the public key.
+	SHA1 digest `json:"sha1"`
+	// SPKISHA256 is the digest of the public key (RFC 7469).
+	SPKISHA256 digest `json:"spki_sha256"`
+	// Pin is the SPKI pin in the form "sha256/<base64>".
+	Pin string `json:"pin"`
+}
+
+// digest is the hash in the accepted formats.
+type digest struct {
+	Colon  string `json:"colon"`
+	Hex    string `json:"hex"`
+	Base64 string `json:"base64"`
+}
+
+func newDigest(sum []byte) digest {
+	colon := make([]string, len(sum))
+	for i, b := range sum {
+		colon[i] = fmt.Sprintf("%02X", b)
+	}
+	return digest{
+		Colon:  strings.Join(colon, ":"),
+		Hex:    hex.EncodeToString(sum),
+		Base64: base64.StdEncoding.EncodeToString(sum),
+	}
+}
+
+func newCertFingerprints(source string, index int, subject, issuer string, f verify.Fingerprints) certFingerprints {
+	// the values of verify.Fingerprints are valid hex and base64
+	sha1Sum, _ := hex.DecodeString(f.SHA1)
+	spkiSum, _ := base64.StdEncoding.DecodeString(f.SPKISHA256)
+	return certFingerprints{
+		Source:     source,
+		Index:      index,
+		Subject:    subject,
+		Issuer:     issuer,
+		SHA1:       newDigest(sha1Sum),
+		SPKISHA256: newDigest(spkiSum),
+		Pin:        "sha256/" + f.SPKISHA256,
+	}
+}
+
+func (c certFingerprints) writeText(w io.Writer) {
+	fmt.Fprintf(w, "%s [%d] %s\n", c.Source, c.Index, c.Subject)
+	fmt.Fprintf(w, "  issuer:        %s\n", c.Issuer)
+	for _, d := range []struct {
+		name string
+		d    digest
+	}{
+		{"sha1", c.SHA1},
+		{"spki sha256", c.SPKISHA256},
+	} {
+		fmt.Fprintf(w, "  %-13s  %s\n", d.name+":", d.d.Colon)
+		fmt.Fprintf(w, "  %-13s  %s\n", "", d.d.Hex)
+		fmt.Fprintf(w, "  %-13s  %s\n", "", d.d.Base64)
+	}
+	fmt.Fprintf(w, "  %-13s  %s\n", "pin:", c.Pin)
+}
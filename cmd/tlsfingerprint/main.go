@@ -0,0 +1,177 @@
+// Command tlsfingerprint prints the fingerprints and the SPKI pins of the certs
+// presented by the servers or stored in the PEM (or DER) files.
+//
+//	tlsfingerprint [-chain] [-json] [-servername name] host:port|file ...
+//
+// The values are printed in the formats accepted by the verify options:
+// colon hex (as openssl prints), plain hex, base64 and "sha256/<base64>" for the SPKI pin.
+// The SHA-256 of the cert is not printed, it is not usable as the pin.
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type config struct {
+	chain      bool
+	json       bool
+	serverName string
+	timeout    time.Duration
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tlsfingerprint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tlsfingerprint [flags] host:port|file|- ...")
+		fs.PrintDefaults()
+	}
+	var cfg config
+	fs.BoolVar(&cfg.chain, "chain", false, "print all certs of the chain, not only the leaf")
+	fs.BoolVar(&cfg.json, "json", false, "print JSON")
+	fs.StringVar(&cfg.serverName, "servername", "", "server name (SNI) to send, the host of the target by default")
+	fs.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "timeout of the connection")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var (
+		certs  []certFingerprints
+		failed bool
+	)
+	for _, target := range fs.Args() {
+		found, err := load(cfg, target, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", target, err)
+			failed = true
+			continue
+		}
+		certs = append(certs, found...)
+	}
+
+	if cfg.json {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if certs == nil {
+			certs = []certFingerprints{}
+		}
+		if err := enc.Encode(certs); err != nil {
+			fmt.Fprintln(stderr, "failed write:", err)
+			return 1
+		}
+	} else {
+		for i, cert := range certs {
+			if i > 0 {
+				fmt.Fprintln(stdout)
+			}
+			cert.writeText(stdout)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// load returns the fingerprints of the certs of the target:
+// the file (or "-" for stdin) if it exists, otherwise the server address.
+func load(cfg config, target string, stdin io.Reader) ([]certFingerprints, error) {
+	var (
+		certs []certFingerprints
+		err   error
+	)
+	if target == "-" {
+		var dat []byte
+		if dat, err = ioutil.ReadAll(stdin); err == nil {
+			certs, err = parseCerts(target, dat)
+		}
+	} else if _, statErr := os.Stat(target); statErr == nil {
+		var dat []byte
+		if dat, err = ioutil.ReadFile(target); err == nil {
+			certs, err = parseCerts(target, dat)
+		}
+	} else {
+		certs, err = fetchCerts(cfg, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.chain {
+		certs = certs[:1]
+	}
+	return certs, nil
+}
+
+// parseCerts parses the PEM certs or the single DER cert.
+func parseCerts(source string, dat []byte) ([]certFingerprints, error) {
+	var certs []*x509.Certificate
+	rest := dat
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parse certificate")
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		cert, err := x509.ParseCertificate(dat)
+		if err != nil {
+			return nil, errors.New("no certificates found")
+		}
+		certs = append(certs, cert)
+	}
+
+	res := make([]certFingerprints, len(certs))
+	for i, cert := range certs {
+		res[i] = newCertFingerprints(source, i, cert.Subject.String(), cert.Issuer.String(), verify.CertFingerprints(cert))
+	}
+	return res, nil
+}
+
+// fetchCerts returns the chain presented by the server, the port is 443 by default.
+func fetchCerts(cfg config, addr string) ([]certFingerprints, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	report, err := verify.Inspect(ctx, addr, verify.SkipTLSVerify(), verify.DNSName(cfg.serverName))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]certFingerprints, len(report.Certificates))
+	for i, cert := range report.Certificates {
+		res[i] = newCertFingerprints(addr, i, cert.Subject, cert.Issuer, cert.Fingerprints)
+	}
+	return res, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const selfsignedOK = "../../test/testdata/verify/ssl/selfsigned-localhost-ok.crt"
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	leafSHA1 := sha1.Sum(srv.Certificate().Raw)
+	addr := srv.Listener.Addr().String()
+
+	t.Run("file", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		require.Equal(t, 0, run([]string{selfsignedOK}, nil, &stdout, &stderr), stderr.String())
+		assert.Contains(t, stdout.String(), "EA:0E:AD:68:81:DF:4F:A4:69:83:DC:FF:12:59:7A:BE:AA:95:A6:11")
+		assert.Contains(t, stdout.String(), "ea0ead6881df4fa46983dcff12597abeaa95a611")
+		assert.Contains(t, stdout.String(), "pin:           sha256/rYrQEL3rldf80Tg4JNKnYYnKMXfcYIIQxo56cRx9a1M=")
+		assert.NotContains(t, stdout.String(), "  sha256:", "the SHA-256 of the cert is not the pin")
+	})
+	t.Run("json", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		require.Equal(t, 0, run([]string{"-json", "-chain", addr, selfsignedOK}, nil, &stdout, &stderr), stderr.String())
+
+		var certs []certFingerprints
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &certs))
+		require.Len(t, certs, 2)
+		assert.Equal(t, addr, certs[0].Source)
+		assert.Equal(t, hex.EncodeToString(leafSHA1[:]), certs[0].SHA1.Hex)
+		assert.Equal(t, selfsignedOK, certs[1].Source)
+		assert.Equal(t, digest{
+			Colon:  "EA:0E:AD:68:81:DF:4F:A4:69:83:DC:FF:12:59:7A:BE:AA:95:A6:11",
+			Hex:    "ea0ead6881df4fa46983dcff12597abeaa95a611",
+			Base64: "6g6taIHfT6Rpg9z/Ell6vqqVphE=",
+		}, certs[1].SHA1)
+		assert.Equal(t, "sha256/rYrQEL3rldf80Tg4JNKnYYnKMXfcYIIQxo56cRx9a1M=", certs[1].Pin)
+	})
+	t.Run("stdin", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin := strings.NewReader("not a cert")
+		assert.Equal(t, 1, run([]string{"-"}, stdin, &stdout, &stderr))
+		assert.Contains(t, stderr.String(), "no certificates found")
+	})
+	t.Run("usage", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, run(nil, nil, &stdout, &stderr))
+	})
+}
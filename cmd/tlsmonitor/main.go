@@ -0,0 +1,147 @@
+// Command tlsmonitor probes the TLS endpoints on an interval and serves
+// their state (see the tls/verify/monitor package).
+//
+//	tlsmonitor -targets targets.json [-listen :9115] [-interval 5m] [-thresholds 30d,7d,1d] [-webhook url]
+//
+// The targets file is the JSON list of {"name", "addr", "server_name", "pin_sha1", "pin_sha256", "skip_chain"}.
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify/monitor"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	os.Exit(run(ctx, os.Args[1:], os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tlsmonitor", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		targetsFile string
+		listen      string
+		caFile      string
+		thresholds  string
+		cfg         monitor.Config
+	)
+	fs.StringVar(&targetsFile, "targets", "", "JSON file of the targets")
+	fs.StringVar(&listen, "listen", ":9115", "address of the HTTP server of /state, /metrics, /healthz and /readyz")
+	fs.StringVar(&caFile, "ca", "", "PEM file of the trusted roots, the system roots by default")
+	fs.DurationVar(&cfg.Interval, "interval", 5*time.Minute, "interval between the probes of the target")
+	fs.DurationVar(&cfg.Jitter, "jitter", 30*time.Second, "max random delay added to the interval")
+	fs.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "timeout of the probe")
+	fs.StringVar(&thresholds, "thresholds", "30d,7d,1d", "comma separated times before the expiry to alert at (Go durations or days like 7d)")
+	fs.StringVar(&cfg.WebhookURL, "webhook", "", "URL to post the alerts to")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if targetsFile == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var err error
+	if cfg.Thresholds, err = parseThresholds(thresholds); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if caFile != "" {
+		if cfg.RootCAs, err = loadRoots(caFile); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+	f, err := os.Open(targetsFile)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed open targets:", err)
+		return 2
+	}
+	targets, err := monitor.LoadTargets(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	m, err := monitor.New(cfg, targets)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	srv := &http.Server{Addr: listen, Handler: m.Handler()}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	go m.Run(ctx)
+
+	select {
+	case err := <-errc:
+		fmt.Fprintln(stderr, "failed serve:", err)
+		return 1
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	return 0
+}
+
+// parseThresholds parses the comma separated durations, the "d" suffix is days.
+func parseThresholds(s string) ([]time.Duration, error) {
+	var thresholds []time.Duration
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.HasSuffix(v, "d") {
+			days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+			if err != nil {
+				return nil, errors.Errorf("invalid threshold %q", v)
+			}
+			thresholds = append(thresholds, time.Duration(days)*24*time.Hour)
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Errorf("invalid threshold %q", v)
+		}
+		thresholds = append(thresholds, d)
+	}
+	return thresholds, nil
+}
+
+func loadRoots(file string) (*x509.CertPool, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read CA file")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(dat) {
+		return nil, errors.Errorf("no certificates in CA file %q", file)
+	}
+	return roots, nil
+}
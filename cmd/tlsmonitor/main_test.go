@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThresholds(t *testing.T) {
+	got, err := parseThresholds("30d, 12h,,1d")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{30 * 24 * time.Hour, 12 * time.Hour, 24 * time.Hour}, got)
+
+	_, err = parseThresholds("7days")
+	assert.EqualError(t, err, `invalid threshold "7days"`)
+}
+
+func TestRun(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	targetsFile := filepath.Join(t.TempDir(), "targets.json")
+	require.NoError(t, ioutil.WriteFile(targetsFile, []byte(`[{"addr": "`+srv.Listener.Addr().String()+`", "skip_chain": true}]`), 0600))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listen := lis.Addr().String()
+	lis.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stderr bytes.Buffer
+	code := make(chan int, 1)
+	go func() {
+		code <- run(ctx, []string{"-targets", targetsFile, "-listen", listen, "-jitter", "1ms"}, &stderr)
+	}()
+	require.Eventually(t, func() bool {
+		res, err := http.Get("http://" + listen + "/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.Equal(t, 0, <-code, stderr.String())
+
+	assert.Equal(t, 2, run(context.Background(), nil, &stderr))
+}
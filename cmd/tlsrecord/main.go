@@ -0,0 +1,57 @@
+// Command tlsrecord records the chain (and the OCSP staple) presented by the endpoint
+// into the testdata file for the replay servers of the verifytest package.
+//
+//	tlsrecord [-servername name] [-timeout 10s] host:port file
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify/recording"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tlsrecord", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tlsrecord [flags] host:port file")
+		fs.PrintDefaults()
+	}
+	var (
+		serverName string
+		timeout    time.Duration
+	)
+	fs.StringVar(&serverName, "servername", "", "server name (SNI) to send, the host of the address by default")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "timeout of the connection")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	addr, file := fs.Arg(0), fs.Arg(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rec, err := recording.Record(ctx, addr, serverName)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", addr, err)
+		return 1
+	}
+	if err := rec.WriteFile(file); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "recorded %d certs of %s (OCSP staple: %t) to %s\n", len(rec.Chain), addr, len(rec.OCSPStaple) > 0, file)
+	return 0
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/gebv/go-lib/tls/verify/recording"
+	"github.com/gebv/go-lib/tls/verify/verifytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	srv := verifytest.NewServer(t, verifytest.TrustedOK)
+	file := filepath.Join(t.TempDir(), "recorded.json")
+
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, run([]string{"-servername", verifytest.ServerName, srv.Addr, file}, &stdout, &stderr), stderr.String())
+	assert.Contains(t, stdout.String(), "recorded 2 certs")
+
+	rec, err := recording.Load(file)
+	require.NoError(t, err)
+	assert.Equal(t, verifytest.ServerName, rec.ServerName)
+	certs, err := rec.Certificates()
+	require.NoError(t, err)
+	assert.True(t, srv.Chain[0].Equal(certs[0]))
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{srv.Addr}, &stdout, &stderr))
+	assert.Equal(t, 1, run([]string{"127.0.0.1:1", file}, &stdout, &stderr))
+}
@@ -7,4 +7,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/sys v0.0.0-20210525143221-35b2ab0089ea
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -1,164 +1,265 @@
 package test
 
 import (
-	"errors"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"io/ioutil"
-	"strings"
+	"net"
+	"net/http"
 	"testing"
+	"time"
 
+	pb "github.com/gebv/go-lib/test/testdata/verify/api/services/simple"
 	"github.com/gebv/go-lib/tls/verify"
+	"github.com/gebv/go-lib/tls/verify/verifytest"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
-const (
-	grpcServer_Direct            = "localhost:10001"
-	grpcServer_SelfsignedOK      = "localhost:10010"
-	grpcServer_SelfsignedExpired = "localhost:10020"
-	grpcServer_SelfsignedSimple  = "localhost:10030"
-	grpcServer_trustedOK         = "localhost:10040"
-	grpcServer_trustedExpired    = "localhost:10050"
-
-	httpServer_Direct            = "localhost:10002"
-	httpServer_SelfsignedOK      = "localhost:10110"
-	httpServer_SelfsignedExpired = "localhost:10120"
-	httpServer_SelfsignedSimple  = "localhost:10130"
-	httpServer_trustedOK         = "localhost:10140"
-	httpServer_trustedExpired    = "localhost:10150"
-
-	selfsignedOK_CertFileFingerprint      = "testdata/verify/ssl/selfsigned-localhost-ok.crt.sha1"
-	selfsignedExpired_CertFileFingerprint = "testdata/verify/ssl/selfsigned-localhost-expired.crt.sha1"
-	selfsignedSimple_CertFileFingerprint  = "testdata/verify/ssl/selfsigned-localhost-simple.crt.sha1"
-	trustedOK_CertFileFingerprint         = "testdata/verify/ssl/trusted-localhost-ok.crt.sha1"
-	trustedExpired_CertFileFingerprint    = "testdata/verify/ssl/trusted-localhost-expired.crt.sha1"
-
-	fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
-)
+const fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
 
-var mapAddrToCert = map[string]string{
-	grpcServer_SelfsignedOK:      selfsignedOK_CertFileFingerprint,
-	grpcServer_SelfsignedExpired: selfsignedExpired_CertFileFingerprint,
-	grpcServer_SelfsignedSimple:  selfsignedSimple_CertFileFingerprint,
-	grpcServer_trustedOK:         trustedOK_CertFileFingerprint,
-	grpcServer_trustedExpired:    trustedExpired_CertFileFingerprint,
-	httpServer_SelfsignedOK:      selfsignedOK_CertFileFingerprint,
-	httpServer_SelfsignedExpired: selfsignedExpired_CertFileFingerprint,
-	httpServer_SelfsignedSimple:  selfsignedSimple_CertFileFingerprint,
-	httpServer_trustedOK:         trustedOK_CertFileFingerprint,
-	httpServer_trustedExpired:    trustedExpired_CertFileFingerprint,
-}
+// echo responds the query parameter "query".
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, r.URL.Query().Get("query"))
+})
 
-func extractFingerptinSHA1(t *testing.T, addr string) string {
-	filePath, exists := mapAddrToCert[addr]
-	if !exists {
-		t.Fatal("not found pre-stored cert for addr:", addr)
-	}
-	dat, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		t.Fatalf("failed read file %q: %v", filePath, err)
-	}
-	const prefix = "SHA1 Fingerprint="
-	if !strings.HasPrefix(string(dat), prefix) {
-		t.Fatalf("failed format file %q - should start with '"+prefix+"': %q", filePath, string(dat))
-	}
-	sha1hex := string(dat)[len(prefix):]
-	if len(sha1hex) == 0 {
-		t.Fatalf("empty sha1 for cert %q", filePath)
-	}
-	sha1hex = strings.TrimSpace(sha1hex)
-	t.Logf("fingerprint %q for %q", sha1hex, addr)
-	return sha1hex
+func query(t *testing.T, c *http.Client, srv *verifytest.Server, want string) {
+	t.Helper()
+
+	res, err := c.Get(srv.URL + "?query=" + want)
+	require.NoError(t, err)
+	dat, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err)
+	assert.EqualValues(t, want, string(dat))
 }
 
 func TestTLSVerify_HTTP_Trusted(t *testing.T) {
-	want := "ok"
-	t.Run("fingerprintOK", func(t *testing.T) {
-		addr := httpServer_trustedOK
-		addrFingerprint := extractFingerptinSHA1(t, addr)
+	trustedOK := verifytest.NewHTTPServer(t, verifytest.TrustedOK, echo)
+	trustedExpired := verifytest.NewHTTPServer(t, verifytest.Expired, echo)
 
-		c := verify.HttpClient(verify.FingerprintSHA1(addrFingerprint))
-
-		res, err := c.Get("https://" + addr + "?query=" + want)
-		assert.NoError(t, err)
-
-		dat, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
-		assert.NoError(t, err)
-		assert.EqualValues(t, want, string(dat))
+	t.Run("fingerprintOK", func(t *testing.T) {
+		c := verify.HttpClient(verify.RootCAs(trustedOK.Roots), verify.FingerprintSHA1(trustedOK.FingerprintSHA1))
+		query(t, c, trustedOK, "ok")
+	})
+	t.Run("pinOK", func(t *testing.T) {
+		c := verify.HttpClient(verify.RootCAs(trustedOK.Roots), verify.PinSHA256(trustedOK.PinSHA256))
+		query(t, c, trustedOK, "ok")
 	})
 	t.Run("fingerprintInvalid", func(t *testing.T) {
-		c := verify.HttpClient(verify.FingerprintSHA1(fingerprintNoRegistred))
-		_, err := c.Get("https://" + httpServer_trustedOK + "?query=ok")
-		assert.Error(t, err)
-		assert.EqualError(t, errors.Unwrap(err), verify.ErrNotMatchedFingerprint.Error())
+		c := verify.HttpClient(verify.RootCAs(trustedOK.Roots), verify.FingerprintSHA1(fingerprintNoRegistred))
+		_, err := c.Get(trustedOK.URL + "?query=ok")
+		assert.ErrorIs(t, err, verify.ErrNotMatchedFingerprint)
 	})
 	t.Run("expired", func(t *testing.T) {
-		c := verify.HttpClient()
-		_, err := c.Get("https://" + httpServer_trustedExpired + "?query=ok")
-		assert.Error(t, err)
-		assert.EqualError(t, errors.Unwrap(err), verify.ErrCertExpired.Error())
+		c := verify.HttpClient(verify.RootCAs(trustedExpired.Roots))
+		_, err := c.Get(trustedExpired.URL + "?query=ok")
+		assert.ErrorIs(t, err, verify.ErrCertExpired)
 	})
 	t.Run("lifetimeCheckedFirst", func(t *testing.T) {
-		c := verify.HttpClient(verify.FingerprintSHA1(fingerprintNoRegistred))
-		_, err := c.Get("https://" + httpServer_trustedExpired + "?query=ok")
-		assert.Error(t, err)
-		assert.EqualError(t, errors.Unwrap(err), verify.ErrCertExpired.Error())
+		c := verify.HttpClient(verify.RootCAs(trustedExpired.Roots), verify.FingerprintSHA1(fingerprintNoRegistred))
+		_, err := c.Get(trustedExpired.URL + "?query=ok")
+		assert.ErrorIs(t, err, verify.ErrCertExpired)
 	})
 }
 
 func TestTLSVerify_HTTP_Selfsigned(t *testing.T) {
-	want := "ok"
+	selfsignedOK := verifytest.NewHTTPServer(t, verifytest.SelfSigned, echo)
 	t.Run("unknownAuthority", func(t *testing.T) {
-		c := verify.HttpClient()
-		_, err := c.Get("https://" + httpServer_SelfsignedOK + "?query=ok")
-		assert.Error(t, err)
-		assert.EqualError(t, errors.Unwrap(err), "x509: certificate signed by unknown authority")
-
+		c := verify.HttpClient(verify.RootCAs(selfsignedOK.Roots))
+		_, err := c.Get(selfsignedOK.URL + "?query=ok")
+		var unknownAuthority x509.UnknownAuthorityError
+		assert.True(t, errors.As(err, &unknownAuthority), "got %v", err)
 	})
 	t.Run("fingerprintOK", func(t *testing.T) {
-		addr := httpServer_SelfsignedOK
-		addrFingerprint := extractFingerptinSHA1(t, addr)
-
 		c := verify.HttpClient(
-			verify.FingerprintSHA1(addrFingerprint),
+			verify.FingerprintSHA1(selfsignedOK.FingerprintSHA1),
 			verify.SkipTLSVerify(),
 		)
-		res, err := c.Get("https://" + addr + "?query=" + want)
-		assert.NoError(t, err)
-		dat, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
-		assert.NoError(t, err)
-		assert.EqualValues(t, want, string(dat))
+		query(t, c, selfsignedOK, "ok")
 	})
 	t.Run("fingerprintInvalid", func(t *testing.T) {
 		c := verify.HttpClient(
 			verify.FingerprintSHA1(fingerprintNoRegistred),
 			verify.SkipTLSVerify(),
 		)
-		_, err := c.Get("https://" + httpServer_SelfsignedOK + "?query=" + want)
-		assert.Error(t, err)
-		assert.EqualError(t, errors.Unwrap(err), verify.ErrNotMatchedFingerprint.Error())
+		_, err := c.Get(selfsignedOK.URL + "?query=ok")
+		assert.ErrorIs(t, err, verify.ErrNotMatchedFingerprint)
 	})
 	t.Run("ok", func(t *testing.T) {
 		c := verify.HttpClient(
 			verify.SkipTLSVerify(),
 		)
-		res, err := c.Get("https://" + httpServer_SelfsignedOK + "?query=" + want)
-		assert.NoError(t, err)
-		dat, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
-		assert.NoError(t, err)
-		assert.EqualValues(t, want, string(dat))
+		query(t, c, selfsignedOK, "ok")
 	})
 	t.Run("expired", func(t *testing.T) {
+		expired := verifytest.NewHTTPServer(t, verifytest.Expired, echo)
 		c := verify.HttpClient(
 			verify.SkipTLSVerify(),
 		)
-		res, err := c.Get("https://" + httpServer_SelfsignedExpired + "?query=" + want)
-		assert.NoError(t, err)
 		// NOTE: why ok? Because skip the verify cert
-		dat, err := ioutil.ReadAll(res.Body)
-		res.Body.Close()
+		query(t, c, expired, "ok")
+	})
+}
+
+func TestTLSVerify_Scenarios(t *testing.T) {
+	cases := []struct {
+		scenario verifytest.Scenario
+		// check is nil if the chain passes the verification
+		check func(t *testing.T, err error)
+		// skip returns the reason to skip the scenario on the toolchain, empty to run it
+		skip func(srv *verifytest.Server) string
+	}{
+		{verifytest.TrustedOK, nil, nil},
+		{verifytest.Expired, func(t *testing.T, err error) {
+			assert.ErrorIs(t, err, verify.ErrCertExpired)
+		}, nil},
+		{verifytest.NotYetValid, func(t *testing.T, err error) {
+			// the lifetime error is the same for the expired and the not yet valid certs
+			assert.ErrorIs(t, err, verify.ErrCertExpired)
+			var verr *verify.VerificationError
+			require.True(t, errors.As(err, &verr), "got %v", err)
+			assert.True(t, verr.Cert.NotBefore.After(time.Now()))
+		}, nil},
+		{verifytest.SelfSigned, func(t *testing.T, err error) {
+			var unknownAuthority x509.UnknownAuthorityError
+			assert.True(t, errors.As(err, &unknownAuthority), "got %v", err)
+		}, nil},
+		{verifytest.WrongHost, func(t *testing.T, err error) {
+			var hostname x509.HostnameError
+			assert.True(t, errors.As(err, &hostname), "got %v", err)
+		}, nil},
+		{verifytest.MissingIntermediate, func(t *testing.T, err error) {
+			var unknownAuthority x509.UnknownAuthorityError
+			assert.True(t, errors.As(err, &unknownAuthority), "got %v", err)
+		}, nil},
+		// SHA-1 signatures are rejected since Go 1.18
+		{verifytest.SHA1Signature, func(t *testing.T, err error) {
+			// the leaf is not accepted as signed by the intermediate
+			var unknownAuthority x509.UnknownAuthorityError
+			assert.True(t, errors.As(err, &unknownAuthority), "got %v", err)
+			assert.Contains(t, err.Error(), "insecure algorithm")
+		}, func(srv *verifytest.Server) string {
+			if srv.Leaf.Cert.CheckSignatureFrom(srv.Intermediate.Cert) == nil {
+				return "the toolchain accepts SHA-1 signatures"
+			}
+			return ""
+		}},
+		{verifytest.WeakKey, nil, nil},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(string(c.scenario), func(t *testing.T) {
+			srv := verifytest.NewHTTPServer(t, c.scenario, echo)
+			client := verify.HttpClient(verify.RootCAs(srv.Roots))
+			if c.check == nil {
+				query(t, client, srv, "ok")
+				return
+			}
+			if c.skip != nil {
+				if reason := c.skip(srv); reason != "" {
+					t.Skip(reason)
+				}
+			}
+			_, err := client.Get(srv.URL + "?query=ok")
+			require.Error(t, err)
+			c.check(t, err)
+
+			// the pins of the presented leaf pass without the chain
+			client = verify.HttpClient(verify.SkipTLSVerify(), verify.PinSHA256(srv.PinSHA256))
+			query(t, client, srv, "ok")
+		})
+	}
+
+	t.Run(string(verifytest.ClientCertRequired), func(t *testing.T) {
+		srv := verifytest.NewServer(t, verifytest.ClientCertRequired)
+		d, err := verify.NewDialer(verify.RootCAs(srv.Roots))
+		require.NoError(t, err)
+		d.Config = &tls.Config{Certificates: []tls.Certificate{srv.ClientCert}}
+		conn, err := d.DialContext(context.Background(), "tcp", srv.Addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		dat, err := ioutil.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(dat))
+	})
+}
+
+// serveGRPC serves the SimpleService over TLS with the certs of the scenario.
+func serveGRPC(t *testing.T, scenario verifytest.Scenario) (*verifytest.Server, string) {
+	t.Helper()
+
+	certs := verifytest.NewCerts(t, scenario)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(certs.TLSConfig())))
+	pb.RegisterSimpleServiceServer(srv, &simpleServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return certs, lis.Addr().String()
+}
+
+type simpleServer struct {
+	pb.UnimplementedSimpleServiceServer
+}
+
+func (s *simpleServer) Echo(ctx context.Context, req *pb.EchoRequest) (*pb.EchoResponse, error) {
+	return &pb.EchoResponse{Out: req.GetIn()}, nil
+}
+
+func echoGRPC(t *testing.T, addr string, opts ...verify.Option) error {
+	t.Helper()
+
+	creds, err := verify.NewGRPCCredentials(opts...)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	res, err := pb.NewSimpleServiceClient(conn).Echo(ctx, &pb.EchoRequest{In: "ok"}, grpc.WaitForReady(false))
+	if err != nil {
+		return err
+	}
+	assert.Equal(t, "ok", res.GetOut())
+	return nil
+}
+
+func TestTLSVerify_GRPC(t *testing.T) {
+	trustedOK, trustedOKAddr := serveGRPC(t, verifytest.TrustedOK)
+	trustedExpired, trustedExpiredAddr := serveGRPC(t, verifytest.Expired)
+	selfsignedOK, selfsignedOKAddr := serveGRPC(t, verifytest.SelfSigned)
+
+	t.Run("fingerprintOK", func(t *testing.T) {
+		err := echoGRPC(t, trustedOKAddr, verify.DNSName(verifytest.ServerName), verify.RootCAs(trustedOK.Roots), verify.FingerprintSHA1(trustedOK.FingerprintSHA1))
 		assert.NoError(t, err)
-		assert.EqualValues(t, want, string(dat))
+	})
+	t.Run("fingerprintInvalid", func(t *testing.T) {
+		err := echoGRPC(t, trustedOKAddr, verify.DNSName(verifytest.ServerName), verify.RootCAs(trustedOK.Roots), verify.FingerprintSHA1(fingerprintNoRegistred))
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Contains(t, err.Error(), verify.ErrNotMatchedFingerprint.Error())
+	})
+	t.Run("expired", func(t *testing.T) {
+		err := echoGRPC(t, trustedExpiredAddr, verify.DNSName(verifytest.ServerName), verify.RootCAs(trustedExpired.Roots))
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Contains(t, err.Error(), verify.ErrCertExpired.Error())
+	})
+	t.Run("selfsignedPinned", func(t *testing.T) {
+		err := echoGRPC(t, selfsignedOKAddr, verify.SkipTLSVerify(), verify.PinSHA256(selfsignedOK.PinSHA256))
+		assert.NoError(t, err)
+	})
+	t.Run("dial", func(t *testing.T) {
+		conn, msg, err := verify.DialGRPC(context.Background(), selfsignedOKAddr, 5*time.Second, verify.GRPCVerify(verify.RootCAs(selfsignedOK.Roots)))
+		assert.Nil(t, conn)
+		assert.ErrorIs(t, err, verify.ErrUnknownAuthority)
+		assert.Contains(t, msg, selfsignedOKAddr)
 	})
 }
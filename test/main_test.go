@@ -6,10 +6,8 @@ import (
 	"log"
 	"math/rand"
 	"os"
-	"os/exec"
 	"os/signal"
 	"runtime/debug"
-	"strings"
 	"testing"
 	"time"
 
@@ -32,7 +30,6 @@ func TestMain(m *testing.M) {
 	}
 
 	log.Println("Start TestMain.")
-	log.Println("Use SKIP_TLSVERIFY_INFRA env for skip install infra for tls/verify tests.")
 
 	var cancel context.CancelFunc
 	Ctx, cancel = context.WithCancel(context.Background())
@@ -60,26 +57,9 @@ func TestMain(m *testing.M) {
 		os.Exit(exitCode)
 	}()
 
-	if os.Getenv("SKIP_TLSVERIFY_INFRA") == "" {
-		runMake("-C", "./testdata/verify", "gen-trusted-ssl")
-		runMake("-C", "./testdata/verify", "run-servers")
-	} else {
-		log.Println("Skipted installing infrastructure for tls/verify tests.")
-	}
-
 	log.Println("running tests")
 	exitCode = m.Run()
 	log.Println("tests completed - canceling the main context")
 	cancel()
 	log.Println("bye bye.")
 }
-
-func runMake(args ...string) {
-	cmd := exec.Command("make", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	log.Print("runMake: run make with args:", strings.Join(cmd.Args, " "))
-	if err := cmd.Run(); err != nil {
-		log.Panic("runMake: failed run make task, with error:", err)
-	}
-}
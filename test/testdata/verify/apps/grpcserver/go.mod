@@ -1,8 +0,0 @@
-module github.com/gebv/go-lib/tls/verify/testdata/apps/grpcserver
-
-go 1.15
-
-require (
-	github.com/gebv/grpc-conn-err-human-msg v0.0.0-20210529185834-2542f7f079b0
-	google.golang.org/grpc v1.38.0
-)
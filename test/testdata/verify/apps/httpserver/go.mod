@@ -1,3 +0,0 @@
-module github.com/gebv/go-lib/tls/verify/testdata/apps/httpserver
-
-go 1.15
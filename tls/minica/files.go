@@ -0,0 +1,118 @@
+package minica
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CertPEM returns the PEM of the cert.
+func (c *Cert) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM returns the PEM of the private key in PKCS #8.
+func (c *Cert) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed marshal private key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// CSRPEM returns the PEM of the certificate request with the subject, the SANs and the key of the cert.
+func (c *Cert) CSRPEM() ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        c.Cert.Subject,
+		DNSNames:       c.Cert.DNSNames,
+		IPAddresses:    c.Cert.IPAddresses,
+		EmailAddresses: c.Cert.EmailAddresses,
+		URIs:           c.Cert.URIs,
+	}, c.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed create certificate request")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// Fingerprint returns the SHA-1 fingerprint of the cert
+// as "openssl x509 -noout -fingerprint -sha1" prints it.
+func (c *Cert) Fingerprint() string {
+	sum := sha1.Sum(c.Cert.Raw)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return "SHA1 Fingerprint=" + strings.Join(hex, ":")
+}
+
+// WriteFiles writes the cert to dir/name.crt, the private key to dir/name.key
+// and the fingerprint to dir/name.crt.sha1.
+func (c *Cert) WriteFiles(dir, name string) error {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(base+".crt", c.CertPEM(), 0644); err != nil {
+		return errors.Wrap(err, "failed write cert")
+	}
+	if err := ioutil.WriteFile(base+".key", keyPEM, 0600); err != nil {
+		return errors.Wrap(err, "failed write private key")
+	}
+	if err := ioutil.WriteFile(base+".crt.sha1", []byte(c.Fingerprint()+"\n"), 0644); err != nil {
+		return errors.Wrap(err, "failed write fingerprint")
+	}
+	return nil
+}
+
+// WriteCSR writes the certificate request to dir/name.csr (see CSRPEM).
+func (c *Cert) WriteCSR(dir, name string) error {
+	csrPEM, err := c.CSRPEM()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(ioutil.WriteFile(filepath.Join(dir, name+".csr"), csrPEM, 0644), "failed write certificate request")
+}
+
+// Load reads the cert and its private key from the PEM (or DER) files.
+func Load(certFile, keyFile string) (*Cert, error) {
+	dat, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read cert")
+	}
+	if block, _ := pem.Decode(dat); block != nil {
+		dat = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(dat)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed parse cert %q", certFile)
+	}
+
+	dat, err = ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read private key")
+	}
+	if block, _ := pem.Decode(dat); block != nil {
+		if x509.IsEncryptedPEMBlock(block) {
+			return nil, errors.Errorf("encrypted private key %q is not supported", keyFile)
+		}
+		dat = block.Bytes
+	}
+	key, err := parsePrivateKey(dat)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed parse private key %q", keyFile)
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && !pub.Equal(cert.PublicKey) {
+		return nil, errors.Errorf("private key %q does not match cert %q", keyFile, certFile)
+	}
+	return &Cert{Cert: cert, Key: key}, nil
+}
@@ -0,0 +1,89 @@
+package minica
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeyType is the type of the generated key.
+type KeyType string
+
+const (
+	ECDSAP256 KeyType = "ecdsa-p256"
+	ECDSAP384 KeyType = "ecdsa-p384"
+	RSA1024   KeyType = "rsa-1024"
+	RSA2048   KeyType = "rsa-2048"
+	RSA3072   KeyType = "rsa-3072"
+	RSA4096   KeyType = "rsa-4096"
+	Ed25519   KeyType = "ed25519"
+)
+
+// KeyTypes are the supported key types.
+var KeyTypes = []KeyType{ECDSAP256, ECDSAP384, RSA1024, RSA2048, RSA3072, RSA4096, Ed25519}
+
+// ParseKeyType returns the key type by the name, case-insensitive.
+func ParseKeyType(s string) (KeyType, error) {
+	for _, kt := range KeyTypes {
+		if strings.EqualFold(s, string(kt)) {
+			return kt, nil
+		}
+	}
+	return "", errors.Errorf("unknown key type %q", s)
+}
+
+// GenerateKey generates the key of the type, ECDSA P-256 if the type is empty.
+// RSA1024 is weak and intended for the tests of the weak keys only.
+func GenerateKey(kt KeyType) (crypto.Signer, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch kt {
+	case ECDSAP256, "":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case RSA1024:
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+	case RSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case RSA3072:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case RSA4096:
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	case Ed25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, errors.Errorf("unknown key type %q", kt)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed generate %s key", kt)
+	}
+	return key, nil
+}
+
+// parsePrivateKey parses the PKCS #8, PKCS #1 or SEC 1 DER of the private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.Errorf("unsupported private key %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("neither PKCS #8, PKCS #1 nor SEC 1 private key")
+}
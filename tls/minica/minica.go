@@ -0,0 +1,200 @@
+// Package minica is the minimal CA for the tests and the development:
+// it creates the root and intermediate CAs, issues the server and client certs
+// and writes them in the PEM files with the fingerprint sidecars.
+package minica
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultValidity is the validity of the issued certs.
+	DefaultValidity = 365 * 24 * time.Hour
+	// DefaultCAValidity is the validity of the CAs.
+	DefaultCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+// Request describes the cert to create.
+type Request struct {
+	Subject pkix.Name
+	// Hosts are the SANs: IP addresses, emails (with "@"), URIs (with "://") or DNS names.
+	// The first host is the common name if the subject has none.
+	Hosts []string
+
+	// NotBefore is the start of the validity, now if zero.
+	// It may be in the past (backdated) or in the future (not yet valid).
+	NotBefore time.Time
+	// NotAfter is the end of the validity, NotBefore+Validity if zero.
+	// It may be in the past (expired).
+	NotAfter time.Time
+	// Validity is the lifetime if NotAfter is zero, DefaultValidity (DefaultCAValidity for the CAs) if zero.
+	Validity time.Duration
+
+	// KeyType is the type of the generated key, ECDSA P-256 if empty.
+	KeyType KeyType
+	// Key is used instead of the generated key.
+	Key crypto.Signer
+	// ExtKeyUsage are the extended key usages. If empty, the server auth is set
+	// by IssueServer and SelfSigned, the client auth by IssueClient.
+	ExtKeyUsage []x509.ExtKeyUsage
+	// SignatureAlgorithm is chosen by the key of the issuer if zero.
+	SignatureAlgorithm x509.SignatureAlgorithm
+}
+
+// Cert is the created cert with its private key.
+type Cert struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// NewRoot creates the self-signed root CA.
+func NewRoot(req Request) (*Cert, error) {
+	return create(req, nil, true)
+}
+
+// NewIntermediate creates the intermediate CA issued by the CA.
+func (ca *Cert) NewIntermediate(req Request) (*Cert, error) {
+	return create(req, ca, true)
+}
+
+// IssueServer issues the server cert.
+func (ca *Cert) IssueServer(req Request) (*Cert, error) {
+	if len(req.ExtKeyUsage) == 0 {
+		req.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	return create(req, ca, false)
+}
+
+// IssueClient issues the client cert.
+func (ca *Cert) IssueClient(req Request) (*Cert, error) {
+	if len(req.ExtKeyUsage) == 0 {
+		req.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	return create(req, ca, false)
+}
+
+// Issue issues the cert with the extended key usages of the request only.
+func (ca *Cert) Issue(req Request) (*Cert, error) {
+	return create(req, ca, false)
+}
+
+// SelfSigned creates the self-signed server cert (not CA).
+func SelfSigned(req Request) (*Cert, error) {
+	if len(req.ExtKeyUsage) == 0 {
+		req.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	return create(req, nil, false)
+}
+
+// TLSCertificate returns the cert with the chain (the issuers of the cert, the root is usually omitted).
+func (c *Cert) TLSCertificate(chain ...*Cert) tls.Certificate {
+	cert := tls.Certificate{
+		Certificate: [][]byte{c.Cert.Raw},
+		PrivateKey:  c.Key,
+		Leaf:        c.Cert,
+	}
+	for _, parent := range chain {
+		cert.Certificate = append(cert.Certificate, parent.Cert.Raw)
+	}
+	return cert
+}
+
+// create signs the template of the request by the parent (self-signed if parent is nil).
+func create(req Request, parent *Cert, isCA bool) (*Cert, error) {
+	key := req.Key
+	if key == nil {
+		var err error
+		if key, err = GenerateKey(req.KeyType); err != nil {
+			return nil, err
+		}
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed generate serial")
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:       serial,
+		Subject:            req.Subject,
+		NotBefore:          req.NotBefore,
+		NotAfter:           req.NotAfter,
+		ExtKeyUsage:        req.ExtKeyUsage,
+		SignatureAlgorithm: req.SignatureAlgorithm,
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+	}
+	if err := setHosts(tmpl, req.Hosts); err != nil {
+		return nil, err
+	}
+	if tmpl.Subject.CommonName == "" && len(req.Hosts) > 0 {
+		tmpl.Subject.CommonName = req.Hosts[0]
+	}
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now()
+	}
+	if tmpl.NotAfter.IsZero() {
+		validity := req.Validity
+		if validity == 0 {
+			validity = DefaultValidity
+			if isCA {
+				validity = DefaultCAValidity
+			}
+		}
+		tmpl.NotAfter = tmpl.NotBefore.Add(validity)
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else if _, ok := key.Public().(*rsa.PublicKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	signer, signerCert := key, tmpl
+	if parent != nil {
+		signer, signerCert = parent.Key, parent.Cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, key.Public(), signer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed create cert %q", tmpl.Subject.CommonName)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parse cert")
+	}
+	return &Cert{Cert: cert, Key: key}, nil
+}
+
+// setHosts puts the hosts into the SANs of the template.
+func setHosts(tmpl *x509.Certificate, hosts []string) error {
+	for _, host := range hosts {
+		switch {
+		case net.ParseIP(host) != nil:
+			tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(host))
+		case strings.Contains(host, "://"):
+			u, err := url.Parse(host)
+			if err != nil {
+				return errors.Wrapf(err, "invalid URI SAN %q", host)
+			}
+			tmpl.URIs = append(tmpl.URIs, u)
+		case strings.Contains(host, "@"):
+			tmpl.EmailAddresses = append(tmpl.EmailAddresses, host)
+		case host == "":
+			return errors.New("empty host")
+		default:
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	return nil
+}
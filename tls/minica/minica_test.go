@@ -0,0 +1,133 @@
+package minica
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssue(t *testing.T) {
+	root, err := NewRoot(Request{Subject: pkix.Name{CommonName: "root"}})
+	require.NoError(t, err)
+	intermediate, err := root.NewIntermediate(Request{Subject: pkix.Name{CommonName: "intermediate"}})
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate.Cert)
+
+	t.Run("server", func(t *testing.T) {
+		server, err := intermediate.IssueServer(Request{Hosts: []string{"localhost", "127.0.0.1", "admin@example.com", "spiffe://example.com/web"}})
+		require.NoError(t, err)
+		assert.Equal(t, "localhost", server.Cert.Subject.CommonName)
+		assert.Equal(t, []string{"localhost"}, server.Cert.DNSNames)
+		assert.Equal(t, "127.0.0.1", server.Cert.IPAddresses[0].String())
+		assert.Equal(t, []string{"admin@example.com"}, server.Cert.EmailAddresses)
+		assert.Equal(t, "spiffe://example.com/web", server.Cert.URIs[0].String())
+		assert.False(t, server.Cert.IsCA)
+
+		_, err = server.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "localhost"})
+		assert.NoError(t, err)
+		_, err = server.Cert.Verify(x509.VerifyOptions{
+			Roots: roots, Intermediates: intermediates,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		assert.Error(t, err, "server cert should not be valid for the client auth")
+	})
+	t.Run("client", func(t *testing.T) {
+		client, err := root.IssueClient(Request{Subject: pkix.Name{CommonName: "client"}})
+		require.NoError(t, err)
+		_, err = client.Cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		assert.NoError(t, err)
+	})
+	t.Run("expired", func(t *testing.T) {
+		expired, err := root.IssueServer(Request{
+			Hosts:     []string{"localhost"},
+			NotBefore: time.Now().Add(-48 * time.Hour),
+			Validity:  24 * time.Hour,
+		})
+		require.NoError(t, err)
+		assert.True(t, expired.Cert.NotAfter.Before(time.Now()))
+		_, err = expired.Cert.Verify(x509.VerifyOptions{Roots: roots})
+		var invalid x509.CertificateInvalidError
+		require.ErrorAs(t, err, &invalid)
+		assert.Equal(t, x509.Expired, invalid.Reason)
+	})
+	t.Run("selfSigned", func(t *testing.T) {
+		cert, err := SelfSigned(Request{Hosts: []string{"localhost"}})
+		require.NoError(t, err)
+		assert.Equal(t, cert.Cert.Subject.String(), cert.Cert.Issuer.String())
+		assert.False(t, cert.Cert.IsCA)
+	})
+	t.Run("keyTypes", func(t *testing.T) {
+		for _, kt := range KeyTypes {
+			if kt == RSA3072 || kt == RSA4096 {
+				continue // slow
+			}
+			cert, err := root.IssueServer(Request{Hosts: []string{"localhost"}, KeyType: kt})
+			require.NoError(t, err, kt)
+			switch key := cert.Cert.PublicKey.(type) {
+			case *rsa.PublicKey:
+				assert.Equal(t, string(kt), "rsa-"+strconv.Itoa(key.N.BitLen()))
+				assert.NotZero(t, cert.Cert.KeyUsage&x509.KeyUsageKeyEncipherment)
+			case *ecdsa.PublicKey:
+				assert.Equal(t, string(kt), map[int]string{256: "ecdsa-p256", 384: "ecdsa-p384"}[key.Curve.Params().BitSize])
+			case ed25519.PublicKey:
+				assert.Equal(t, Ed25519, kt)
+			}
+		}
+		_, err := ParseKeyType("RSA-2048")
+		assert.NoError(t, err)
+		_, err = ParseKeyType("dsa")
+		assert.Error(t, err)
+	})
+	t.Run("invalidHost", func(t *testing.T) {
+		_, err := root.IssueServer(Request{Hosts: []string{""}})
+		assert.Error(t, err)
+	})
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	root, err := NewRoot(Request{Subject: pkix.Name{CommonName: "root"}, KeyType: RSA2048})
+	require.NoError(t, err)
+	require.NoError(t, root.WriteFiles(dir, "root"))
+	loaded, err := Load(filepath.Join(dir, "root.crt"), filepath.Join(dir, "root.key"))
+	require.NoError(t, err)
+	assert.True(t, root.Cert.Equal(loaded.Cert))
+
+	leaf, err := loaded.IssueServer(Request{Hosts: []string{"localhost"}})
+	require.NoError(t, err)
+	require.NoError(t, leaf.WriteFiles(dir, "leaf"))
+	require.NoError(t, leaf.WriteCSR(dir, "leaf"))
+
+	// the sidecar and the files are read by the verify package
+	fingerprint, err := verify.FingerprintSHA1FromFile(filepath.Join(dir, "leaf.crt.sha1"))
+	require.NoError(t, err)
+	fromCert, err := verify.FingerprintSHA1FromFile(filepath.Join(dir, "leaf.crt"))
+	require.NoError(t, err)
+	assert.Equal(t, fromCert, fingerprint)
+	for _, ext := range []string{".crt", ".key", ".csr"} {
+		pin, err := verify.PinFromFile(filepath.Join(dir, "leaf"+ext))
+		require.NoError(t, err, ext)
+		assert.Equal(t, "sha256/"+verify.CertFingerprints(leaf.Cert).SPKISHA256, pin, ext)
+	}
+
+	dat, err := ioutil.ReadFile(filepath.Join(dir, "leaf.crt.sha1"))
+	require.NoError(t, err)
+	assert.Regexp(t, `^SHA1 Fingerprint=([0-9A-F]{2}:){19}[0-9A-F]{2}\n$`, string(dat))
+
+	_, err = Load(filepath.Join(dir, "leaf.crt"), filepath.Join(dir, "root.key"))
+	assert.Error(t, err, "key should not match cert")
+}
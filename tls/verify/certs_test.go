@@ -0,0 +1,135 @@
+package verify
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// issueTestCert signs the template by the parent (self-signed if parent is nil).
+func issueTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("failed generate key:", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		t.Fatal("failed generate serial:", err)
+	}
+	tmpl.SerialNumber = serial
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+	}
+
+	signer, signerCert := crypto.Signer(key), tmpl
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, key.Public(), signer)
+	if err != nil {
+		t.Fatal("failed create cert:", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("failed parse cert:", err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	return issueTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, parent)
+}
+
+func newTestLeaf(t *testing.T, parent *testCert, dnsNames ...string) *testCert {
+	t.Helper()
+	return issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, parent)
+}
+
+func rawChain(certs ...*testCert) [][]byte {
+	raw := make([][]byte, len(certs))
+	for i, c := range certs {
+		raw[i] = c.cert.Raw
+	}
+	return raw
+}
+
+func (c *testCert) tlsCertificate(chain ...*testCert) tls.Certificate {
+	cert := tls.Certificate{
+		Certificate: [][]byte{c.cert.Raw},
+		PrivateKey:  c.key,
+	}
+	for _, parent := range chain {
+		cert.Certificate = append(cert.Certificate, parent.cert.Raw)
+	}
+	return cert
+}
+
+func (c *testCert) sha1() string {
+	sum := sha1.Sum(c.cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// matchPin returns the MatchPin check of the public key of the cert.
+func (c *testCert) matchPin(t *testing.T) Check {
+	t.Helper()
+
+	check, err := MatchPin(CertFingerprints(c.cert).SPKISHA256)
+	if err != nil {
+		t.Fatal("failed pin:", err)
+	}
+	return check
+}
+
+// serveTLS accepts the TLS connections and writes "ok" to them.
+func serveTLS(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal("failed listen:", err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("ok"))
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
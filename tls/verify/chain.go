@@ -0,0 +1,46 @@
+package verify
+
+import (
+	"encoding/pem"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CapturedChain is the chain presented in the failed handshake.
+type CapturedChain struct {
+	// Raw are the DER certs, leaf first.
+	Raw [][]byte
+	// Fingerprints of the Raw certs.
+	Fingerprints []Fingerprints
+}
+
+func newCapturedChain(rawCerts [][]byte) *CapturedChain {
+	c := &CapturedChain{
+		Raw:          make([][]byte, len(rawCerts)),
+		Fingerprints: make([]Fingerprints, len(rawCerts)),
+	}
+	for i, der := range rawCerts {
+		c.Raw[i] = append([]byte(nil), der...)
+		c.Fingerprints[i] = rawFingerprints(der)
+	}
+	return c
+}
+
+// PEM returns the chain in PEM, leaf first.
+func (c *CapturedChain) PEM() string {
+	var b strings.Builder
+	for _, der := range c.Raw {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return b.String()
+}
+
+// CapturedChainFromError returns the chain captured in the failed handshake (see CaptureChain).
+func CapturedChainFromError(err error) (*CapturedChain, bool) {
+	var verr *VerificationError
+	if errors.As(err, &verr) && verr.Chain != nil {
+		return verr.Chain, true
+	}
+	return nil, false
+}
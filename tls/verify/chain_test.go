@@ -0,0 +1,53 @@
+package verify
+
+import (
+	"encoding/pem"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureChain(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, root, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate(root))
+	const fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
+
+	var events []Event
+	c := HttpClient(
+		SkipTLSVerify(),
+		FingerprintSHA1(fingerprintNoRegistred),
+		CaptureChain(),
+		Observer(func(e Event) { events = append(events, e) }),
+	)
+	_, err := c.Get(srv.URL)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrNotMatchedFingerprint))
+	assert.EqualError(t, errors.Unwrap(err), ErrNotMatchedFingerprint.Error())
+
+	chain, ok := CapturedChainFromError(err)
+	require.True(t, ok)
+	require.Len(t, chain.Raw, 2)
+	assert.Equal(t, leaf.sha1(), chain.Fingerprints[0].SHA1)
+	assert.Equal(t, CertFingerprints(root.cert), chain.Fingerprints[1])
+
+	rest := []byte(chain.PEM())
+	for _, want := range []*testCert{leaf, root} {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		require.NotNil(t, block)
+		assert.Equal(t, want.cert.Raw, block.Bytes)
+	}
+
+	require.Len(t, events, 1)
+	assert.Same(t, chain, events[0].Chain)
+
+	t.Run("notCaptured", func(t *testing.T) {
+		_, err := HttpClient(SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred)).Get(srv.URL)
+		require.Error(t, err)
+		_, ok := CapturedChainFromError(err)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,308 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCheckPassed is the error of Not when the negated check passed.
+var ErrCheckPassed = errors.New("check passed but should fail")
+
+// CheckExpiry is the name of the NotExpired check in Result.Checks.
+const CheckExpiry = "expiry"
+
+// Chain is the chain presented by the peer under the verification (see Check).
+type Chain struct {
+	// Certificates are the presented certs, leaf first. There is the leaf at least.
+	Certificates []*x509.Certificate
+	// ServerName is the expected name of the peer (the DNSName), it may be empty.
+	ServerName string
+	// Roots are the roots of the RootCAs option, nil for the system roots.
+	Roots *x509.CertPool
+	// KeyUsages are the usages the leaf is verified for by TrustedChain, the server auth if empty.
+	KeyUsages []x509.ExtKeyUsage
+	// VerifiedChains are set by the passed TrustedChain (or TrustedSVID) check.
+	VerifiedChains [][]*x509.Certificate
+	// SPIFFEID is the authenticated SPIFFE ID set by the passed TrustedSVID check.
+	SPIFFEID string
+
+	// passed are the names of the passed checks
+	passed []string
+}
+
+// Leaf returns the leaf cert.
+func (c *Chain) Leaf() *x509.Certificate {
+	return c.Certificates[0]
+}
+
+// chainState is the state of the chain set by the passed checks.
+type chainState struct {
+	passed   int
+	verified [][]*x509.Certificate
+	spiffeID string
+}
+
+func (c *Chain) save() chainState {
+	return chainState{passed: len(c.passed), verified: c.VerifiedChains, spiffeID: c.SPIFFEID}
+}
+
+// restore drops the state set by the checks after the save.
+func (c *Chain) restore(s chainState) {
+	c.passed, c.VerifiedChains, c.SPIFFEID = c.passed[:s.passed], s.verified, s.spiffeID
+}
+
+// Check is the check of the presented chain, the error fails the verification
+// (or is the violation in the report-only mode).
+type Check interface {
+	Check(ctx context.Context, chain *Chain) error
+}
+
+// CheckFunc is the function of the check.
+type CheckFunc func(ctx context.Context, chain *Chain) error
+
+func (f CheckFunc) Check(ctx context.Context, chain *Chain) error {
+	return f(ctx, chain)
+}
+
+// Checks adds the checks, they are run after the built-in checks of the options
+// (the chain, DANE or SPIFFE, the fingerprint, the pins and the identities).
+// To combine the chain verification with the other checks (e.g. by Any)
+// use SkipTLSVerify and the TrustedChain check.
+func Checks(checks ...Check) Option {
+	return func(opts *Options) {
+		opts.Checks = append(opts.Checks, checks...)
+	}
+}
+
+// NamedCheck returns the check that adds the name to Result.Checks if it passes.
+func NamedCheck(name string, check Check) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		if err := check.Check(ctx, chain); err != nil {
+			return err
+		}
+		chain.passed = append(chain.passed, name)
+		return nil
+	})
+}
+
+// All passes if all checks pass, the checks are run in order until the first failure.
+func All(checks ...Check) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		for _, check := range checks {
+			if err := check.Check(ctx, chain); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AnyError is the error of Any when none of the checks passed.
+// errors.Is and errors.As match the errors of all checks.
+type AnyError struct {
+	Errs []error
+}
+
+func (e *AnyError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return "none of the checks passed: " + strings.Join(msgs, "; ")
+}
+
+func (e *AnyError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *AnyError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Any passes if one of the checks passes, the checks are run in order until the first pass.
+// The error is *AnyError.
+func Any(checks ...Check) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		errs := make([]error, 0, len(checks))
+		for _, check := range checks {
+			state := chain.save()
+			err := check.Check(ctx, chain)
+			if err == nil {
+				return nil
+			}
+			chain.restore(state)
+			errs = append(errs, err)
+		}
+		return &AnyError{Errs: errs}
+	})
+}
+
+// Not passes if the check fails.
+func Not(check Check) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		state := chain.save()
+		err := check.Check(ctx, chain)
+		chain.restore(state)
+		if err == nil {
+			return ErrCheckPassed
+		}
+		return nil
+	})
+}
+
+// TrustedChain verifies the chain to the roots and the ServerName of the chain if it is set.
+// If roots is nil the Roots of the chain are used.
+// The expired cert fails with ErrCertExpired.
+func TrustedChain(roots *x509.CertPool) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		pool := roots
+		if pool == nil {
+			pool = chain.Roots
+		}
+		chains, err := verifyChain(chain.Certificates, pool, chain.ServerName, chain.KeyUsages...)
+		if err != nil {
+			return err
+		}
+		chain.VerifiedChains = chains
+		chain.passed = append(chain.passed, CheckChain)
+		if chain.ServerName != "" {
+			chain.passed = append(chain.passed, CheckHostname)
+		}
+		return nil
+	})
+}
+
+// MatchHostname verifies that the leaf is valid for the name, for the ServerName of the chain if name is empty.
+// The error is x509.HostnameError.
+func MatchHostname(name string) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		host := name
+		if host == "" {
+			host = chain.ServerName
+		}
+		if host == "" {
+			return errors.New("no server name to match")
+		}
+		if err := chain.Leaf().VerifyHostname(host); err != nil {
+			return err
+		}
+		chain.passed = append(chain.passed, CheckHostname)
+		return nil
+	})
+}
+
+// NotExpired verifies that all presented certs are in their validity period.
+// The error is ErrCertExpired (the cert is in the VerificationError).
+func NotExpired() Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		t := time.Now()
+		for _, cert := range chain.Certificates {
+			if t.Before(cert.NotBefore) || t.After(cert.NotAfter) {
+				return &VerificationError{Err: ErrCertExpired, Cert: cert}
+			}
+		}
+		chain.passed = append(chain.passed, CheckExpiry)
+		return nil
+	})
+}
+
+// MatchPin returns the check that the leaf public key matches one of the pins (see PinSHA256).
+// The pins are parsed here, the malformed pin is the error.
+func MatchPin(pins ...string) (Check, error) {
+	sums, err := (&Options{PinsSHA256: pins}).pinsSHA256()
+	if err != nil {
+		return nil, err
+	}
+	return pinCheck(sums), nil
+}
+
+// MatchFingerprintSHA1 returns the check of the SHA-1 fingerprint of the leaf (see FingerprintSHA1).
+// The fingerprint is parsed here, the malformed fingerprint is the error.
+func MatchFingerprintSHA1(fingerprint string) (Check, error) {
+	sum, err := parseFingerprintOf("sha1", fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	return fingerprintCheck(sum), nil
+}
+
+func pinCheck(pins [][]byte) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		got := sha256.Sum256(chain.Leaf().RawSubjectPublicKeyInfo)
+		for _, want := range pins {
+			if bytes.Equal(want, got[:]) {
+				chain.passed = append(chain.passed, CheckPin)
+				return nil
+			}
+		}
+		return ErrNotMatchedPin
+	})
+}
+
+func fingerprintCheck(want []byte) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		got := sha1.Sum(chain.Leaf().Raw)
+		if !bytes.Equal(want, got[:]) {
+			return ErrNotMatchedFingerprint
+		}
+		chain.passed = append(chain.passed, CheckFingerprint)
+		return nil
+	})
+}
+
+func daneCheck(opts *Options) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		if err := verifyDANE(ctx, opts, chain.Certificates); err != nil {
+			return err
+		}
+		chain.passed = append(chain.passed, CheckDANE)
+		return nil
+	})
+}
+
+// checks returns the built-in checks of the options followed by the added checks.
+func (o *Options) checks() ([]Check, error) {
+	var checks []Check
+	if o.TLSAResolver != nil {
+		checks = append(checks, daneCheck(o))
+	} else if len(o.SPIFFEBundles) > 0 {
+		checks = append(checks, TrustedSVID(o.SPIFFEBundles, o.SPIFFEIDs...))
+	} else if !o.SkipTLSVerify {
+		checks = append(checks, TrustedChain(nil))
+	}
+	if o.SHA1Fingerprint != "" {
+		sum, err := parseFingerprintOf("sha1", o.SHA1Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, fingerprintCheck(sum))
+	}
+	if len(o.PinsSHA256) > 0 {
+		pins, err := o.pinsSHA256()
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, pinCheck(pins))
+	}
+	for _, matchers := range o.Identities {
+		checks = append(checks, MatchCert(matchers...))
+	}
+	return append(checks, o.Checks...), nil
+}
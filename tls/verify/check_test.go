@@ -0,0 +1,155 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecks(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	trusted := newTestLeaf(t, root, "localhost")
+	selfSigned := newTestLeaf(t, nil, "localhost")
+	other := newTestLeaf(t, nil, "localhost")
+	expired := issueTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "localhost"},
+		DNSNames:  []string{"localhost"},
+		NotBefore: time.Now().Add(-2 * time.Hour),
+		NotAfter:  time.Now().Add(-time.Hour),
+	}, root)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	// verify returns the result and the error of the verification of the chain
+	verify := func(t *testing.T, chain [][]byte, opts ...Option) (*Result, error) {
+		t.Helper()
+		var res *Result
+		v := TLSVerifyPeerCertificate(append(opts, Observer(func(e Event) { res = e.Result }))...)
+		err := v.Option()(chain, nil)
+		require.NotNil(t, res)
+		return res, err
+	}
+
+	t.Run("chainOrPinnedSelfSigned", func(t *testing.T) {
+		policy := Any(TrustedChain(roots), selfSigned.matchPin(t))
+
+		res, err := verify(t, rawChain(trusted, root), SkipTLSVerify(), DNSName("localhost"), Checks(policy))
+		require.NoError(t, err)
+		assert.Equal(t, []string{CheckChain, CheckHostname}, res.Checks)
+		assert.NotEmpty(t, res.VerifiedChains)
+
+		res, err = verify(t, rawChain(selfSigned), SkipTLSVerify(), Checks(policy))
+		require.NoError(t, err)
+		assert.Equal(t, []string{CheckPin}, res.Checks)
+		assert.Empty(t, res.VerifiedChains)
+
+		res, err = verify(t, rawChain(other), SkipTLSVerify(), Checks(policy))
+		var anyErr *AnyError
+		require.True(t, errors.As(err, &anyErr), "got %v", err)
+		assert.Len(t, anyErr.Errs, 2)
+		assert.ErrorIs(t, err, ErrNotMatchedPin)
+		var authErr x509.UnknownAuthorityError
+		assert.True(t, errors.As(err, &authErr))
+		assert.Empty(t, res.Checks)
+		assert.Contains(t, Explain(err), "None of the accepted checks passed: certificate for localhost is self-signed and not trusted")
+		assert.Contains(t, Explain(err), "; certificate for localhost does not match the pinned public key")
+	})
+	t.Run("anyFailedBranch", func(t *testing.T) {
+		// the chain of the failed branch is not kept
+		policy := Any(All(TrustedChain(roots), other.matchPin(t)), MatchHostname("localhost"))
+		res, err := verify(t, rawChain(trusted, root), SkipTLSVerify(), Checks(policy))
+		require.NoError(t, err)
+		assert.Equal(t, []string{CheckHostname}, res.Checks)
+		assert.Empty(t, res.VerifiedChains)
+
+		res, err = verify(t, rawChain(trusted, root), SkipTLSVerify(), ReportOnly(), Checks(All(TrustedChain(roots), other.matchPin(t))))
+		require.NoError(t, err)
+		assert.Len(t, res.Violations, 1)
+		assert.Empty(t, res.Checks)
+		assert.Empty(t, res.VerifiedChains)
+	})
+	t.Run("builtinsFirst", func(t *testing.T) {
+		var called bool
+		custom := CheckFunc(func(ctx context.Context, chain *Chain) error {
+			called = true
+			return nil
+		})
+		_, err := verify(t, rawChain(selfSigned), RootCAs(roots), Checks(custom))
+		assert.Error(t, err)
+		assert.False(t, called)
+
+		res, err := verify(t, rawChain(trusted), RootCAs(roots), Checks(NamedCheck("custom", custom)))
+		require.NoError(t, err)
+		assert.True(t, called)
+		assert.Equal(t, []string{CheckChain, "custom"}, res.Checks)
+	})
+	t.Run("all", func(t *testing.T) {
+		res, err := verify(t, rawChain(trusted), SkipTLSVerify(), Checks(All(NotExpired(), MatchHostname("localhost"))))
+		require.NoError(t, err)
+		assert.Equal(t, []string{CheckExpiry, CheckHostname}, res.Checks)
+
+		_, err = verify(t, rawChain(expired), SkipTLSVerify(), Checks(All(NotExpired(), MatchHostname("localhost"))))
+		assert.ErrorIs(t, err, ErrCertExpired)
+		var verr *VerificationError
+		require.True(t, errors.As(err, &verr))
+		assert.True(t, verr.Cert.Equal(expired.cert))
+
+		_, err = verify(t, rawChain(trusted), SkipTLSVerify(), Checks(All(NotExpired(), MatchHostname("example.com"))))
+		var hostErr x509.HostnameError
+		assert.True(t, errors.As(err, &hostErr), "got %v", err)
+	})
+	t.Run("not", func(t *testing.T) {
+		notSelfSigned := Not(selfSigned.matchPin(t))
+		res, err := verify(t, rawChain(trusted), SkipTLSVerify(), Checks(notSelfSigned))
+		require.NoError(t, err)
+		assert.Empty(t, res.Checks)
+
+		_, err = verify(t, rawChain(selfSigned), SkipTLSVerify(), Checks(notSelfSigned))
+		assert.ErrorIs(t, err, ErrCheckPassed)
+	})
+	t.Run("reportOnly", func(t *testing.T) {
+		failed := CheckFunc(func(ctx context.Context, chain *Chain) error { return errors.New("custom failed") })
+		res, err := verify(t, rawChain(trusted), RootCAs(roots), ReportOnly(), Checks(failed, NamedCheck("ok", All())))
+		require.NoError(t, err)
+		require.Len(t, res.Violations, 1)
+		assert.EqualError(t, res.Violations[0], "custom failed")
+		assert.Equal(t, []string{CheckChain, "ok"}, res.Checks)
+	})
+	t.Run("malformedPin", func(t *testing.T) {
+		check, err := MatchPin("abc")
+		assert.Nil(t, check)
+		var ferr *FingerprintError
+		assert.True(t, errors.As(err, &ferr), "got %v", err)
+
+		check, err = MatchFingerprintSHA1("sha256/" + CertFingerprints(trusted.cert).SPKISHA256)
+		assert.Nil(t, check)
+		assert.True(t, errors.As(err, &ferr), "got %v", err)
+
+		check, err = MatchFingerprintSHA1(trusted.sha1())
+		require.NoError(t, err)
+		res, err := verify(t, rawChain(trusted), SkipTLSVerify(), Checks(check))
+		require.NoError(t, err)
+		assert.Equal(t, []string{CheckFingerprint}, res.Checks)
+	})
+	t.Run("context", func(t *testing.T) {
+		type key struct{}
+		var got interface{}
+		custom := CheckFunc(func(ctx context.Context, chain *Chain) error {
+			got = ctx.Value(key{})
+			return nil
+		})
+		addr := serveTLS(t, trusted.tlsCertificate())
+		d, err := NewDialer(RootCAs(roots), DNSName("localhost"), Checks(custom))
+		require.NoError(t, err)
+		conn, err := d.DialContext(context.WithValue(context.Background(), key{}, "value"), "tcp", addr)
+		require.NoError(t, err)
+		conn.Close()
+		assert.Equal(t, "value", got)
+	})
+}
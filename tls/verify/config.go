@@ -0,0 +1,284 @@
+package verify
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the serializable config of the verifier (JSON, YAML or environment).
+// The options that are not serializable (the DANE resolver, the observers)
+// are added in code to the options of the Config.
+type Config struct {
+	// SkipChainVerify skips the chain and the hostname verification (env TLS_SKIP_CHAIN_VERIFY).
+	SkipChainVerify bool `json:"skip_chain_verify,omitempty" yaml:"skip_chain_verify,omitempty"`
+	// ServerName is the checked name of the server (env TLS_SERVER_NAME).
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	// PinSHA1 is the SHA-1 fingerprint of the leaf cert in hex (env TLS_PIN_SHA1).
+	PinSHA1 string `json:"pin_sha1,omitempty" yaml:"pin_sha1,omitempty"`
+	// PinsSHA256 are the SPKI pins of the leaf (env TLS_PIN_SHA256, comma separated), see PinSHA256.
+	PinsSHA256 []string `json:"pins_sha256,omitempty" yaml:"pins_sha256,omitempty"`
+	// PinFiles are the cert, CSR or key files to take the SPKI pins from (env TLS_PIN_FILE, comma separated), see PinFromFile.
+	PinFiles []string `json:"pin_files,omitempty" yaml:"pin_files,omitempty"`
+	// SPIFFEBundles are the trust bundle files of the SPIFFE trust domains
+	// (env TLS_SPIFFE_BUNDLE, comma separated "domain=file"), see LoadSPIFFEBundle.
+	SPIFFEBundles map[string]string `json:"spiffe_bundles,omitempty" yaml:"spiffe_bundles,omitempty"`
+	// SPIFFEIDs are the allowed SPIFFE IDs, the ID ending with "/" is the prefix (env TLS_SPIFFE_ID, comma separated).
+	SPIFFEIDs []string `json:"spiffe_ids,omitempty" yaml:"spiffe_ids,omitempty"`
+	// CAFile is the PEM file of the trusted roots, the system roots by default (env TLS_CA_FILE).
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	// ReportOnly enables the report-only mode (env TLS_REPORT_ONLY).
+	ReportOnly bool `json:"report_only,omitempty" yaml:"report_only,omitempty"`
+	// ReportURI is the collector of the violation reports (env TLS_REPORT_URI), see ReportSender.
+	ReportURI string `json:"report_uri,omitempty" yaml:"report_uri,omitempty"`
+	// CaptureChain keeps the chain of the failed handshake (env TLS_CAPTURE_CHAIN).
+	CaptureChain bool `json:"capture_chain,omitempty" yaml:"capture_chain,omitempty"`
+}
+
+// FieldError is the error of the invalid field of the Config.
+// The Field is the JSON name of the field.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError is the list of the errors of the invalid fields.
+type ConfigError []*FieldError
+
+func (e ConfigError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fieldErr := range e {
+		msgs[i] = fieldErr.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// ConfigFromEnv returns the Config from the TLS_* environment variables.
+func ConfigFromEnv() (Config, error) {
+	return configFromEnv(os.LookupEnv)
+}
+
+func configFromEnv(lookup func(string) (string, bool)) (Config, error) {
+	var (
+		c    Config
+		errs ConfigError
+	)
+	boolVar := func(dst *bool, name, field string) {
+		v, ok := lookup(name)
+		if !ok || v == "" {
+			return
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, &FieldError{Field: field, Err: errors.Errorf("invalid %s %q", name, v)})
+			return
+		}
+		*dst = b
+	}
+
+	boolVar(&c.SkipChainVerify, "TLS_SKIP_CHAIN_VERIFY", "skip_chain_verify")
+	c.ServerName, _ = lookup("TLS_SERVER_NAME")
+	c.PinSHA1, _ = lookup("TLS_PIN_SHA1")
+	if v, _ := lookup("TLS_PIN_SHA256"); v != "" {
+		for _, pin := range strings.Split(v, ",") {
+			c.PinsSHA256 = append(c.PinsSHA256, strings.TrimSpace(pin))
+		}
+	}
+	if v, _ := lookup("TLS_PIN_FILE"); v != "" {
+		for _, file := range strings.Split(v, ",") {
+			c.PinFiles = append(c.PinFiles, strings.TrimSpace(file))
+		}
+	}
+	if v, _ := lookup("TLS_SPIFFE_BUNDLE"); v != "" {
+		c.SPIFFEBundles = make(map[string]string)
+		for _, bundle := range strings.Split(v, ",") {
+			parts := strings.SplitN(strings.TrimSpace(bundle), "=", 2)
+			if len(parts) != 2 {
+				errs = append(errs, &FieldError{Field: "spiffe_bundles", Err: errors.Errorf("invalid TLS_SPIFFE_BUNDLE %q, expected domain=file", bundle)})
+				continue
+			}
+			c.SPIFFEBundles[parts[0]] = parts[1]
+		}
+	}
+	if v, _ := lookup("TLS_SPIFFE_ID"); v != "" {
+		for _, id := range strings.Split(v, ",") {
+			c.SPIFFEIDs = append(c.SPIFFEIDs, strings.TrimSpace(id))
+		}
+	}
+	c.CAFile, _ = lookup("TLS_CA_FILE")
+	boolVar(&c.ReportOnly, "TLS_REPORT_ONLY", "report_only")
+	c.ReportURI, _ = lookup("TLS_REPORT_URI")
+	boolVar(&c.CaptureChain, "TLS_CAPTURE_CHAIN", "capture_chain")
+
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// Validate returns the ConfigError if the config is invalid.
+// The CA, the pin and the bundle files are read.
+func (c Config) Validate() error {
+	_, err := c.validate()
+	return err
+}
+
+// configFiles are the contents of the files of the Config.
+type configFiles struct {
+	roots         *x509.CertPool
+	pins          []string
+	spiffeBundles map[string]*x509.CertPool
+}
+
+// validate returns the contents of the files if the config is valid.
+func (c Config) validate() (*configFiles, error) {
+	var (
+		files = &configFiles{}
+		errs  ConfigError
+	)
+	fieldErr := func(field string, err error) {
+		errs = append(errs, &FieldError{Field: field, Err: err})
+	}
+
+	if c.PinSHA1 != "" {
+		if _, err := parseFingerprintOf("sha1", c.PinSHA1); err != nil {
+			fieldErr("pin_sha1", err)
+		}
+	}
+	for i, pin := range c.PinsSHA256 {
+		if _, err := parseFingerprintOf("sha256", pin); err != nil {
+			fieldErr("pins_sha256["+strconv.Itoa(i)+"]", err)
+		}
+	}
+	for i, file := range c.PinFiles {
+		pin, err := PinFromFile(file)
+		if err != nil {
+			fieldErr("pin_files["+strconv.Itoa(i)+"]", err)
+			continue
+		}
+		files.pins = append(files.pins, pin)
+	}
+	domains := make([]string, 0, len(c.SPIFFEBundles))
+	for td := range c.SPIFFEBundles {
+		domains = append(domains, td)
+	}
+	sort.Strings(domains)
+	for _, td := range domains {
+		field := "spiffe_bundles[" + td + "]"
+		if err := validateTrustDomain(td); err != nil {
+			fieldErr(field, err)
+			continue
+		}
+		roots, err := LoadSPIFFEBundle(c.SPIFFEBundles[td])
+		if err != nil {
+			fieldErr(field, err)
+			continue
+		}
+		if files.spiffeBundles == nil {
+			files.spiffeBundles = make(map[string]*x509.CertPool)
+		}
+		files.spiffeBundles[td] = roots
+	}
+	for i, id := range c.SPIFFEIDs {
+		if _, err := ParseSPIFFEID(strings.TrimSuffix(id, "/")); err != nil {
+			fieldErr("spiffe_ids["+strconv.Itoa(i)+"]", err)
+		}
+	}
+	if len(c.SPIFFEIDs) > 0 && len(c.SPIFFEBundles) == 0 {
+		fieldErr("spiffe_ids", errors.New("no spiffe_bundles"))
+	}
+	if c.CAFile != "" {
+		var err error
+		if files.roots, err = loadRoots(c.CAFile); err != nil {
+			fieldErr("ca_file", err)
+		}
+	}
+	if c.ReportURI != "" {
+		if u, err := url.Parse(c.ReportURI); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fieldErr("report_uri", errors.Errorf("invalid URL %q", c.ReportURI))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return files, nil
+}
+
+// Options returns the options of the config or the ConfigError.
+// The reports to the ReportURI are not in the options, see ReportSender.
+func (c Config) Options() ([]Option, error) {
+	files, err := c.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []Option
+	if c.SkipChainVerify {
+		opts = append(opts, SkipTLSVerify())
+	}
+	if c.ServerName != "" {
+		opts = append(opts, DNSName(c.ServerName))
+	}
+	if c.PinSHA1 != "" {
+		opts = append(opts, FingerprintSHA1(c.PinSHA1))
+	}
+	if pins := append(append([]string(nil), c.PinsSHA256...), files.pins...); len(pins) > 0 {
+		opts = append(opts, PinSHA256(pins...))
+	}
+	if files.roots != nil {
+		opts = append(opts, RootCAs(files.roots))
+	}
+	for td, roots := range files.spiffeBundles {
+		opts = append(opts, SPIFFEBundle(td, roots))
+	}
+	for _, id := range c.SPIFFEIDs {
+		if strings.HasSuffix(id, "/") {
+			opts = append(opts, AllowedSPIFFEIDs(PrefixMatch(id)))
+		} else {
+			opts = append(opts, AllowedSPIFFEIDs(ExactMatch(id)))
+		}
+	}
+	if c.ReportOnly {
+		opts = append(opts, ReportOnly())
+	}
+	if c.CaptureChain {
+		opts = append(opts, CaptureChain())
+	}
+	return opts, nil
+}
+
+// ReportSender returns the started sender of the reports to the ReportURI or nil if it is not set.
+// The sender is not in the Options, add it by ReportTo and close it when the verifier is not used.
+func (c Config) ReportSender() *ReportSender {
+	if c.ReportURI == "" {
+		return nil
+	}
+	return NewReportSender(ReportSenderConfig{URL: c.ReportURI})
+}
+
+func loadRoots(file string) (*x509.CertPool, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(dat) {
+		return nil, errors.Errorf("no certificates in %q", file)
+	}
+	return roots, nil
+}
@@ -0,0 +1,165 @@
+package verify
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfig(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, root, "localhost")
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}), 0600))
+	pin := CertFingerprints(leaf.cert).SPKISHA256
+
+	c := Config{
+		ServerName: "localhost",
+		PinSHA1:    leaf.sha1(),
+		PinsSHA256: []string{"sha256/" + pin},
+		CAFile:     caFile,
+	}
+
+	t.Run("roundTrip", func(t *testing.T) {
+		dat, err := json.Marshal(c)
+		require.NoError(t, err)
+		var fromJSON Config
+		require.NoError(t, json.Unmarshal(dat, &fromJSON))
+		assert.Equal(t, c, fromJSON)
+
+		dat, err = yaml.Marshal(c)
+		require.NoError(t, err)
+		var fromYAML Config
+		require.NoError(t, yaml.Unmarshal(dat, &fromYAML))
+		assert.Equal(t, c, fromYAML)
+	})
+	t.Run("verifier", func(t *testing.T) {
+		opts, err := c.Options()
+		require.NoError(t, err)
+
+		var events []Event
+		v := TLSVerifyPeerCertificate(append(opts, Observer(func(e Event) { events = append(events, e) }))...)
+		require.NoError(t, v.Option()(rawChain(leaf, root), nil))
+		require.Len(t, events, 1)
+		assert.Equal(t, []string{CheckChain, CheckHostname, CheckFingerprint, CheckPin}, events[0].Result.Checks)
+		assert.Equal(t, []string{"sha1/" + leaf.sha1(), "sha256/" + pin}, events[0].KnownPins)
+
+		other := newTestLeaf(t, root, "localhost")
+		err = TLSVerifyPeerCertificate(append(opts, FingerprintSHA1(other.sha1()))...).Option()(rawChain(other, root), nil)
+		assert.True(t, errors.Is(err, ErrNotMatchedPin), err)
+	})
+	t.Run("reportSender", func(t *testing.T) {
+		assert.Nil(t, c.ReportSender())
+		assert.Nil(t, Config{}.ReportSender())
+
+		withReports := c
+		withReports.ReportURI = "https://collector.local/report"
+		sender := withReports.ReportSender()
+		require.NotNil(t, sender)
+		assert.NoError(t, sender.Close())
+	})
+	t.Run("pinFiles", func(t *testing.T) {
+		opts, err := Config{SkipChainVerify: true, PinFiles: []string{testdataSSL + "selfsigned-localhost-ok.key", caFile}}.Options()
+		require.NoError(t, err)
+		assert.NoError(t, TLSVerifyPeerCertificate(opts...).Option()(rawChain(root), nil))
+		assert.True(t, errors.Is(TLSVerifyPeerCertificate(opts...).Option()(rawChain(leaf), nil), ErrNotMatchedPin))
+	})
+	t.Run("spiffe", func(t *testing.T) {
+		web := newTestSVID(t, root, "spiffe://example.org/ns/prod/web")
+		opts, err := Config{
+			SPIFFEBundles: map[string]string{"example.org": caFile},
+			SPIFFEIDs:     []string{"spiffe://example.org/api", "spiffe://example.org/ns/prod/"},
+		}.Options()
+		require.NoError(t, err)
+		var res *Result
+		require.NoError(t, TLSVerifyPeerCertificate(append(opts, Observer(func(e Event) { res = e.Result }))...).Option()(rawChain(web), nil))
+		assert.Equal(t, "spiffe://example.org/ns/prod/web", res.SPIFFEID)
+
+		other := newTestSVID(t, root, "spiffe://example.org/ns/dev/web")
+		assert.ErrorIs(t, TLSVerifyPeerCertificate(opts...).Option()(rawChain(other), nil), ErrNotMatchedIdentity)
+
+		err = Config{
+			SPIFFEBundles: map[string]string{"example.org": filepath.Join(t.TempDir(), "missing.pem"), "Example.org": caFile},
+			SPIFFEIDs:     []string{"spiffe://example.org/web/", "https://example.org/web"},
+		}.Validate()
+		var configErr ConfigError
+		require.True(t, errors.As(err, &configErr), err)
+		fields := make([]string, len(configErr))
+		for i, fieldErr := range configErr {
+			fields[i] = fieldErr.Field
+		}
+		assert.Equal(t, []string{"spiffe_bundles[Example.org]", "spiffe_bundles[example.org]", "spiffe_ids[1]"}, fields)
+		assert.EqualError(t, Config{SPIFFEIDs: []string{"spiffe://example.org/web"}}.Validate(), "invalid config: spiffe_ids: no spiffe_bundles")
+	})
+	t.Run("invalid", func(t *testing.T) {
+		invalid := Config{
+			PinSHA1:    "abc",
+			PinsSHA256: []string{pin, "sha256/abc"},
+			PinFiles:   []string{caFile, filepath.Join(t.TempDir(), "missing.key")},
+			CAFile:     filepath.Join(t.TempDir(), "missing.crt"),
+			ReportURI:  "collector.local/report",
+		}
+		err := invalid.Validate()
+		var configErr ConfigError
+		require.True(t, errors.As(err, &configErr), err)
+		fields := make([]string, len(configErr))
+		for i, fieldErr := range configErr {
+			fields[i] = fieldErr.Field
+		}
+		assert.Equal(t, []string{"pin_sha1", "pins_sha256[1]", "pin_files[1]", "ca_file", "report_uri"}, fields)
+
+		_, err = invalid.Options()
+		assert.EqualError(t, err, invalid.Validate().Error())
+	})
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		"TLS_SKIP_CHAIN_VERIFY": "true",
+		"TLS_SERVER_NAME":       "localhost",
+		"TLS_PIN_SHA1":          "81:f3:44:a7:68:6a:80:b4:c5:29:3e:8f:dc:0b:01:60:c8:2c:06:a8",
+		"TLS_PIN_SHA256":        "sha256/a, b",
+		"TLS_PIN_FILE":          "/etc/ssl/server.key",
+		"TLS_SPIFFE_BUNDLE":     "example.org=/etc/spiffe/bundle.pem, other.org=/etc/spiffe/other.json",
+		"TLS_SPIFFE_ID":         "spiffe://example.org/web, spiffe://example.org/ns/prod/",
+		"TLS_CA_FILE":           "/etc/ssl/ca.crt",
+		"TLS_REPORT_ONLY":       "1",
+		"TLS_REPORT_URI":        "https://collector.local/report",
+		"TLS_CAPTURE_CHAIN":     "false",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	c, err := configFromEnv(lookup)
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		SkipChainVerify: true,
+		ServerName:      "localhost",
+		PinSHA1:         "81:f3:44:a7:68:6a:80:b4:c5:29:3e:8f:dc:0b:01:60:c8:2c:06:a8",
+		PinsSHA256:      []string{"sha256/a", "b"},
+		PinFiles:        []string{"/etc/ssl/server.key"},
+		SPIFFEBundles:   map[string]string{"example.org": "/etc/spiffe/bundle.pem", "other.org": "/etc/spiffe/other.json"},
+		SPIFFEIDs:       []string{"spiffe://example.org/web", "spiffe://example.org/ns/prod/"},
+		CAFile:          "/etc/ssl/ca.crt",
+		ReportOnly:      true,
+		ReportURI:       "https://collector.local/report",
+	}, c)
+
+	env["TLS_SPIFFE_BUNDLE"] = "/etc/spiffe/bundle.pem"
+	_, err = configFromEnv(lookup)
+	assert.EqualError(t, err, `invalid config: spiffe_bundles: invalid TLS_SPIFFE_BUNDLE "/etc/spiffe/bundle.pem", expected domain=file`)
+	delete(env, "TLS_SPIFFE_BUNDLE")
+
+	env["TLS_REPORT_ONLY"] = "yes"
+	_, err = configFromEnv(lookup)
+	assert.EqualError(t, err, `invalid config: report_only: invalid TLS_REPORT_ONLY "yes"`)
+}
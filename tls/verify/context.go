@@ -0,0 +1,78 @@
+package verify
+
+import (
+	"context"
+)
+
+type contextOptionsKey struct{}
+
+// ContextWithOptions returns the context with the options that override
+// the options of the verifier for the request (or the dial) with the context.
+// The options are applied over the options of the verifier and the options
+// added before, except the pins: the pins of the context (FingerprintSHA1 and
+// PinSHA256) replace the pins of the verifier. The RoundTripper does not reuse
+// the connections verified by other options for the request: the connections
+// are kept for the options of the returned context (and the contexts derived
+// from it), the options added by another call are the other options even if
+// they are the same.
+func ContextWithOptions(ctx context.Context, opts ...Option) context.Context {
+	prev, _ := ctx.Value(contextOptionsKey{}).([]Option)
+	all := make([]Option, 0, len(prev)+len(opts))
+	all = append(all, prev...)
+	all = append(all, opts...)
+	return context.WithValue(ctx, contextOptionsKey{}, all)
+}
+
+func optionsFromContext(ctx context.Context) []Option {
+	opts, _ := ctx.Value(contextOptionsKey{}).([]Option)
+	return opts
+}
+
+// withContext returns the verifier with the options of the context applied.
+// It returns v if the context has no options.
+func (v *tlsVerifyPeerCertificate) withContext(ctx context.Context) *tlsVerifyPeerCertificate {
+	ctxOpts := optionsFromContext(ctx)
+	if len(ctxOpts) == 0 {
+		return v
+	}
+	opts := v.options().clone()
+	// the pins of the context replace the pins of the verifier
+	if pins := newOptions(ctxOpts...); pins.SHA1Fingerprint != "" || len(pins.PinsSHA256) > 0 {
+		opts.SHA1Fingerprint, opts.PinsSHA256 = "", nil
+	}
+	for _, set := range ctxOpts {
+		set(opts)
+	}
+	derived := &tlsVerifyPeerCertificate{}
+	derived.opts.Store(opts)
+	return derived
+}
+
+// contextPolicy identifies the options of the context by the identity of the
+// options added by ContextWithOptions. The options are funcs, they are not comparable.
+type contextPolicy struct {
+	first *Option
+	n     int
+}
+
+// policyFromContext returns the identity of the options of the context.
+// It returns false if the context has no options.
+func policyFromContext(ctx context.Context) (contextPolicy, bool) {
+	opts := optionsFromContext(ctx)
+	if len(opts) == 0 {
+		return contextPolicy{}, false
+	}
+	return contextPolicy{first: &opts[0], n: len(opts)}, true
+}
+
+type contextAddrKey struct{}
+
+// contextWithAddr returns the context with the dialed address for the events.
+func contextWithAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, contextAddrKey{}, addr)
+}
+
+func addrFromContext(ctx context.Context) string {
+	addr, _ := ctx.Value(contextAddrKey{}).(string)
+	return addr
+}
@@ -0,0 +1,186 @@
+package verify
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextWithOptions(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+	c := HttpClient(SkipTLSVerify())
+
+	do := func(ctx context.Context) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		res.Body.Close()
+		return res, nil
+	}
+
+	// the pooled connection verified without pin
+	res, err := do(context.Background())
+	require.NoError(t, err)
+	got, _ := ResultFromResponse(res)
+	assert.False(t, got.Passed(CheckFingerprint))
+
+	t.Run("pinInvalid", func(t *testing.T) {
+		_, err := do(ContextWithOptions(context.Background(), FingerprintSHA1("81f344a7686a80b4c5293e8fdc0b0160c82c06a8")))
+		assert.True(t, errors.Is(err, ErrNotMatchedFingerprint), "got %v", err)
+	})
+	t.Run("pinOK", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			res, err := do(ContextWithOptions(context.Background(), FingerprintSHA1(leaf.sha1())))
+			require.NoError(t, err)
+			got, ok := ResultFromResponse(res)
+			require.True(t, ok)
+			assert.True(t, got.Passed(CheckFingerprint))
+		}
+	})
+	t.Run("stricter", func(t *testing.T) {
+		ctx := ContextWithOptions(context.Background(), FingerprintSHA1(leaf.sha1()))
+		// the chain verification is enabled back by the second override
+		ctx = ContextWithOptions(ctx, func(opts *Options) { opts.SkipTLSVerify = false })
+		_, err := do(ctx)
+		assert.Error(t, err)
+	})
+	t.Run("default", func(t *testing.T) {
+		res, err := do(context.Background())
+		require.NoError(t, err)
+		got, _ := ResultFromResponse(res)
+		assert.False(t, got.Passed(CheckFingerprint))
+	})
+	t.Run("Dialer", func(t *testing.T) {
+		d, err := NewDialer(SkipTLSVerify())
+		require.NoError(t, err)
+		_, err = d.DialContext(ContextWithOptions(context.Background(), FingerprintSHA1("81f344a7686a80b4c5293e8fdc0b0160c82c06a8")), "tcp", srv.Listener.Addr().String())
+		assert.ErrorIs(t, err, ErrNotMatchedFingerprint)
+	})
+}
+
+func TestContextWithOptions_pins(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	other := newTestLeaf(t, nil, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+	leafPin := CertFingerprints(leaf.cert).SPKISHA256
+	otherPin := CertFingerprints(other.cert).SPKISHA256
+
+	// the client is pinned to the served leaf
+	c := HttpClient(SkipTLSVerify(), PinSHA256(leafPin))
+	do := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+	require.NoError(t, do(context.Background()))
+
+	// the pin of the context replaces the pin of the client
+	assert.ErrorIs(t, do(ContextWithOptions(context.Background(), PinSHA256(otherPin))), ErrNotMatchedPin)
+	assert.ErrorIs(t, do(ContextWithOptions(context.Background(), FingerprintSHA1(other.sha1()))), ErrNotMatchedFingerprint)
+	require.NoError(t, do(ContextWithOptions(context.Background(), FingerprintSHA1(leaf.sha1()))))
+
+	t.Run("notShared", func(t *testing.T) {
+		// the capacity of the slice of the verifier is 4
+		v := TLSVerifyPeerCertificate(AllowedSPIFFEIDs(ExactMatch("a")), AllowedSPIFFEIDs(ExactMatch("b")), AllowedSPIFFEIDs(ExactMatch("c")))
+		require.Equal(t, 4, cap(v.options().SPIFFEIDs))
+		d := v.withContext(ContextWithOptions(context.Background(), AllowedSPIFFEIDs(ExactMatch("d"))))
+		e := v.withContext(ContextWithOptions(context.Background(), AllowedSPIFFEIDs(ExactMatch("e"))))
+
+		assert.Equal(t, []StringMatcher{ExactMatch("a"), ExactMatch("b"), ExactMatch("c"), ExactMatch("d")}, d.options().SPIFFEIDs)
+		assert.Equal(t, []StringMatcher{ExactMatch("a"), ExactMatch("b"), ExactMatch("c"), ExactMatch("e")}, e.options().SPIFFEIDs)
+		assert.Len(t, v.options().SPIFFEIDs, 3)
+	})
+}
+
+func TestContextWithOptions_checks(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	other := newTestLeaf(t, nil, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+	c := HttpClient(SkipTLSVerify())
+
+	do := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	// the checks are the same funcs with the different pins
+	okCtx := ContextWithOptions(context.Background(), Checks(leaf.matchPin(t)))
+	wrongCtx := ContextWithOptions(context.Background(), Checks(other.matchPin(t)))
+
+	require.NoError(t, do(okCtx))
+	assert.ErrorIs(t, do(wrongCtx), ErrNotMatchedPin)
+	c.CloseIdleConnections()
+	assert.ErrorIs(t, do(wrongCtx), ErrNotMatchedPin)
+
+	// the derived context keeps the options and the connections
+	child, cancel := context.WithCancel(okCtx)
+	defer cancel()
+	require.NoError(t, do(child))
+
+	t.Run("checkFuncs", func(t *testing.T) {
+		// the different funcs through the same client
+		var calledA, calledB int
+		checkA := CheckFunc(func(ctx context.Context, chain *Chain) error {
+			calledA++
+			return nil
+		})
+		checkB := CheckFunc(func(ctx context.Context, chain *Chain) error {
+			calledB++
+			return ErrNotMatchedIdentity
+		})
+		ctxA := ContextWithOptions(context.Background(), Checks(checkA))
+		ctxB := ContextWithOptions(context.Background(), Checks(checkB))
+
+		require.NoError(t, do(ctxA))
+		assert.ErrorIs(t, do(ctxB), ErrNotMatchedIdentity)
+		assert.Equal(t, 1, calledA)
+		assert.Equal(t, 1, calledB)
+	})
+	t.Run("limit", func(t *testing.T) {
+		rt := c.Transport.(*RoundTripper)
+		for i := 0; i < maxPolicies+8; i++ {
+			require.NoError(t, do(ContextWithOptions(context.Background(), Checks(leaf.matchPin(t)))))
+		}
+		rt.mu.Lock()
+		assert.Len(t, rt.policies, maxPolicies)
+		assert.Equal(t, maxPolicies, rt.lru.Len())
+		rt.mu.Unlock()
+	})
+}
+
+func TestContextWithOptions_observers(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+	c := HttpClient(SkipTLSVerify())
+
+	var a, b []Event
+	ctxA := ContextWithOptions(context.Background(), Observer(func(e Event) { a = append(a, e) }))
+	ctxB := ContextWithOptions(context.Background(), Observer(func(e Event) { b = append(b, e) }))
+	for _, ctx := range []context.Context{ctxA, ctxB, ctxA, ctxB} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := c.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+	// one handshake per the options, the connections are reused by the same options
+	assert.Len(t, a, 1)
+	assert.Len(t, b, 1)
+}
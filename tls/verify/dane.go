@@ -0,0 +1,214 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"net"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoUsableTLSA      = errors.New("no usable TLSA records")
+	ErrNotMatchedTLSA    = errors.New("not matched TLSA record")
+	errTLSAResolverIsNil = errors.New("TLSA resolver is nil")
+)
+
+// TLSA certificate usages (RFC 6698 section 2.1.1, RFC 7218).
+const (
+	TLSAUsagePKIXTA uint8 = 0
+	TLSAUsagePKIXEE uint8 = 1
+	TLSAUsageDANETA uint8 = 2
+	TLSAUsageDANEEE uint8 = 3
+)
+
+// TLSA selectors (RFC 6698 section 2.1.2).
+const (
+	TLSASelectorCert uint8 = 0
+	TLSASelectorSPKI uint8 = 1
+)
+
+// TLSA matching types (RFC 6698 section 2.1.3).
+const (
+	TLSAMatchingFull   uint8 = 0
+	TLSAMatchingSHA256 uint8 = 1
+	TLSAMatchingSHA512 uint8 = 2
+)
+
+// TLSARecord is the RDATA of a TLSA resource record.
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+// usable reports whether the record has parameters known to the verifier.
+// Unusable records are ignored (RFC 6698 section 4.1).
+func (r TLSARecord) usable() bool {
+	return r.Usage <= TLSAUsageDANEEE &&
+		r.Selector <= TLSASelectorSPKI &&
+		r.MatchingType <= TLSAMatchingSHA512
+}
+
+// Match reports whether the certificate is associated with the record.
+func (r TLSARecord) Match(cert *x509.Certificate) bool {
+	var data []byte
+	switch r.Selector {
+	case TLSASelectorCert:
+		data = cert.Raw
+	case TLSASelectorSPKI:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	switch r.MatchingType {
+	case TLSAMatchingFull:
+	case TLSAMatchingSHA256:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case TLSAMatchingSHA512:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(data, r.Data)
+}
+
+// TLSAResolver looks up TLSA records of the service (_port._tcp.host).
+type TLSAResolver interface {
+	LookupTLSA(ctx context.Context, host string, port int) ([]TLSARecord, error)
+}
+
+// StaticTLSAResolver is the in-memory TLSAResolver. The key is "host:port".
+type StaticTLSAResolver map[string][]TLSARecord
+
+func (r StaticTLSAResolver) LookupTLSA(ctx context.Context, host string, port int) ([]TLSARecord, error) {
+	return r[net.JoinHostPort(host, strconv.Itoa(port))], nil
+}
+
+// verifyDANE applies RFC 6698/7671 semantics to the presented chain.
+// The chain is accepted if at least one usable record matches.
+func verifyDANE(ctx context.Context, opts *Options, certs []*x509.Certificate) error {
+	if opts.TLSAResolver == nil {
+		return errTLSAResolverIsNil
+	}
+	host := opts.TLSAHost
+	if host == "" {
+		host = opts.DNSName
+	}
+	records, err := opts.TLSAResolver.LookupTLSA(ctx, host, opts.TLSAPort)
+	if err != nil {
+		return errors.Wrap(err, "failed to lookup TLSA records")
+	}
+
+	var usable []TLSARecord
+	for _, rec := range records {
+		if rec.usable() {
+			usable = append(usable, rec)
+		}
+	}
+	if len(usable) == 0 {
+		return ErrNoUsableTLSA
+	}
+
+	// PKIX usages share the validation against the roots.
+	var (
+		pkixChains [][]*x509.Certificate
+		pkixErr    error
+		pkixDone   bool
+	)
+	pkix := func() ([][]*x509.Certificate, error) {
+		if !pkixDone {
+			pkixChains, pkixErr = verifyChain(certs, opts.RootCAs, opts.DNSName)
+			pkixDone = true
+		}
+		return pkixChains, pkixErr
+	}
+
+	var lastErr error = ErrNotMatchedTLSA
+	for _, rec := range usable {
+		switch rec.Usage {
+		case TLSAUsageDANEEE:
+			// RFC 7671 section 5.1: the name and the validity period
+			// of the end entity are not checked.
+			if rec.Match(certs[0]) {
+				return nil
+			}
+		case TLSAUsageDANETA:
+			if err := verifyDANETA(rec, certs, host); err == nil {
+				return nil
+			} else if err != ErrNotMatchedTLSA {
+				lastErr = err
+			}
+		case TLSAUsagePKIXEE:
+			if !rec.Match(certs[0]) {
+				continue
+			}
+			if _, err := pkix(); err != nil {
+				lastErr = err
+				continue
+			}
+			return nil
+		case TLSAUsagePKIXTA:
+			chains, err := pkix()
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			for _, chain := range chains {
+				for _, cert := range chain[1:] {
+					if rec.Match(cert) {
+						return nil
+					}
+				}
+			}
+		}
+	}
+	return lastErr
+}
+
+// verifyDANETA validates the leaf up to the trust anchor matched by the
+// record. The trust anchor is taken from the presented chain or, for the
+// "2 0 0" records, from the record itself (RFC 7671 section 5.2.2).
+func verifyDANETA(rec TLSARecord, certs []*x509.Certificate, host string) error {
+	var anchors []*x509.Certificate
+	for _, cert := range certs[1:] {
+		if rec.Match(cert) {
+			anchors = append(anchors, cert)
+		}
+	}
+	if len(anchors) == 0 && rec.Selector == TLSASelectorCert && rec.MatchingType == TLSAMatchingFull {
+		if cert, err := x509.ParseCertificate(rec.Data); err == nil {
+			anchors = append(anchors, cert)
+		}
+	}
+	if len(anchors) == 0 {
+		return ErrNotMatchedTLSA
+	}
+
+	roots := x509.NewCertPool()
+	for _, cert := range anchors {
+		roots.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	certErr := x509.CertificateInvalidError{}
+	if errors.As(err, &certErr) && certErr.Reason == x509.Expired {
+		return &VerificationError{Err: ErrCertExpired, Cert: certErr.Cert}
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSARecord_Match(t *testing.T) {
+	ca := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, ca, "example.com")
+
+	sha256Cert := sha256.Sum256(leaf.cert.Raw)
+	sha512SPKI := sha512.Sum512(leaf.cert.RawSubjectPublicKeyInfo)
+	sha256SPKI := sha256.Sum256(leaf.cert.RawSubjectPublicKeyInfo)
+
+	tests := []struct {
+		name string
+		rec  TLSARecord
+		want bool
+	}{
+		{"cert-full", TLSARecord{3, 0, 0, leaf.cert.Raw}, true},
+		{"cert-sha256", TLSARecord{3, 0, 1, sha256Cert[:]}, true},
+		{"spki-sha256", TLSARecord{3, 1, 1, sha256SPKI[:]}, true},
+		{"spki-sha512", TLSARecord{3, 1, 2, sha512SPKI[:]}, true},
+		{"spki-as-cert", TLSARecord{3, 0, 1, sha256SPKI[:]}, false},
+		{"unknownSelector", TLSARecord{3, 2, 1, sha256SPKI[:]}, false},
+		{"unknownMatchingType", TLSARecord{3, 1, 3, sha256SPKI[:]}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rec.Match(leaf.cert))
+		})
+	}
+}
+
+func TestDANE(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	inter := newTestCA(t, "intermediate", root)
+	leaf := newTestLeaf(t, inter, "example.com")
+	expired := issueTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "example.com"},
+		DNSNames:  []string{"example.com"},
+		NotBefore: time.Now().Add(-2 * time.Hour),
+		NotAfter:  time.Now().Add(-time.Hour),
+	}, nil)
+
+	spki := func(c *testCert) []byte {
+		sum := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+		return sum[:]
+	}
+
+	tests := []struct {
+		name    string
+		records []TLSARecord
+		chain   [][]byte
+		host    string
+		wantErr error
+	}{
+		{
+			name:    "DANE-EE",
+			records: []TLSARecord{{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchingSHA256, spki(leaf)}},
+			chain:   rawChain(leaf, inter),
+		},
+		{
+			name:    "DANE-EE-expiredAndAnotherName",
+			records: []TLSARecord{{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchingSHA256, spki(expired)}},
+			chain:   rawChain(expired),
+			host:    "other.com",
+		},
+		{
+			name:    "DANE-EE-notMatched",
+			records: []TLSARecord{{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchingSHA256, spki(inter)}},
+			chain:   rawChain(leaf, inter),
+			wantErr: ErrNotMatchedTLSA,
+		},
+		{
+			name:    "DANE-TA",
+			records: []TLSARecord{{TLSAUsageDANETA, TLSASelectorSPKI, TLSAMatchingSHA256, spki(inter)}},
+			chain:   rawChain(leaf, inter),
+		},
+		{
+			name:    "DANE-TA-fullCertInRecord",
+			records: []TLSARecord{{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingFull, root.cert.Raw}},
+			chain:   rawChain(leaf, inter),
+		},
+		{
+			name:    "DANE-TA-wrongName",
+			records: []TLSARecord{{TLSAUsageDANETA, TLSASelectorSPKI, TLSAMatchingSHA256, spki(inter)}},
+			chain:   rawChain(leaf, inter),
+			host:    "other.com",
+			wantErr: x509.HostnameError{},
+		},
+		{
+			name:    "PKIX-EE-unknownAuthority",
+			records: []TLSARecord{{TLSAUsagePKIXEE, TLSASelectorSPKI, TLSAMatchingSHA256, spki(leaf)}},
+			chain:   rawChain(leaf, inter),
+			wantErr: x509.UnknownAuthorityError{},
+		},
+		{
+			name:    "unusableOnly",
+			records: []TLSARecord{{4, TLSASelectorSPKI, TLSAMatchingSHA256, spki(leaf)}},
+			chain:   rawChain(leaf, inter),
+			wantErr: ErrNoUsableTLSA,
+		},
+		{
+			name:    "noRecords",
+			chain:   rawChain(leaf, inter),
+			wantErr: ErrNoUsableTLSA,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := tt.host
+			if host == "" {
+				host = "example.com"
+			}
+			resolver := StaticTLSAResolver{"example.com:443": tt.records}
+			if host != "example.com" {
+				resolver[host+":443"] = tt.records
+			}
+
+			v := TLSVerifyPeerCertificate(DANE(resolver, host, 443))
+			err := v.Option()(tt.chain, nil)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				assert.NoError(t, err)
+			case x509.HostnameError:
+				assert.True(t, errors.As(err, &want), err)
+			case x509.UnknownAuthorityError:
+				assert.True(t, errors.As(err, &want), err)
+			default:
+				assert.ErrorIs(t, err, want)
+			}
+			assert.Equal(t, err, v.Wait())
+		})
+	}
+}
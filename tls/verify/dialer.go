@@ -0,0 +1,109 @@
+package verify
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// Dialer dials TLS connections with the verification of the server cert.
+// The result of the verification is available by ConnResult.
+// The zero Dialer verifies by the default options: the chain to the system roots and the hostname.
+type Dialer struct {
+	// NetDialer dials the underlying connection. If nil the zero net.Dialer is used.
+	NetDialer *net.Dialer
+	// Config is the base TLS config. It may be nil.
+	Config *tls.Config
+
+	v *tlsVerifyPeerCertificate
+}
+
+// NewDialer returns the Dialer.
+func NewDialer(opts ...Option) (*Dialer, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer{v: v}, nil
+}
+
+// DialContext connects to the address and returns the connection after the verified handshake.
+// The host of addr is checked if the DNSName option and Config.ServerName are not set.
+// The options of the context are applied (see ContextWithOptions).
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (*tls.Conn, error) {
+	netDialer := d.NetDialer
+	if netDialer == nil {
+		netDialer = &net.Dialer{}
+	}
+	rawConn, err := netDialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.client(ctx, d.verifier().withContext(ctx), rawConn, addr)
+}
+
+// defaultVerifier is the verifier of the zero Dialer.
+var defaultVerifier = TLSVerifyPeerCertificate()
+
+// verifier returns the verifier of the options of NewDialer or the default one.
+func (d *Dialer) verifier() *tlsVerifyPeerCertificate {
+	if d.v == nil {
+		return defaultVerifier
+	}
+	return d.v
+}
+
+// client runs the handshake verified by v over the established connection.
+func (d *Dialer) client(ctx context.Context, v *tlsVerifyPeerCertificate, rawConn net.Conn, addr string) (*tls.Conn, error) {
+	serverName := hostname(addr)
+	if d.Config != nil && d.Config.ServerName != "" {
+		serverName = d.Config.ServerName
+	}
+
+	var res *Result
+	cfg := v.tlsConfig(contextWithAddr(ctx, addr), d.Config, serverName, func(r *Result, err error) {
+		if err == nil {
+			res = r
+		}
+	})
+
+	rc := &resultConn{Conn: rawConn}
+	conn := tls.Client(rc, cfg)
+	rc.tlsConn = conn
+	if err := handshake(ctx, conn); err != nil {
+		return nil, err
+	}
+	if res != nil {
+		connResults.Store(conn, res)
+	}
+	return conn, nil
+}
+
+// ConnResult returns the result of the verification of the connection
+// established by the Dialer (or accepted by the Listener after the handshake).
+// The result is available until the connection is closed.
+func ConnResult(conn *tls.Conn) (*Result, bool) {
+	res, ok := connResults.Load(conn)
+	if !ok {
+		return nil, false
+	}
+	return res.(*Result), true
+}
+
+// connResults is the map of *tls.Conn to *Result.
+var connResults sync.Map
+
+// resultConn forgets the result when the connection is closed.
+type resultConn struct {
+	net.Conn
+	tlsConn *tls.Conn
+	once    sync.Once
+}
+
+func (c *resultConn) Close() error {
+	c.once.Do(func() {
+		connResults.Delete(c.tlsConn)
+	})
+	return c.Conn.Close()
+}
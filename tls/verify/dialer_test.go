@@ -0,0 +1,91 @@
+package verify
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialer(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	addr := serveTLS(t, leaf.tlsCertificate())
+
+	t.Run("fingerprintOK", func(t *testing.T) {
+		d, err := NewDialer(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+		require.NoError(t, err)
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		require.NoError(t, err)
+
+		dat, err := ioutil.ReadAll(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", string(dat))
+
+		res, ok := ConnResult(conn)
+		require.True(t, ok)
+		assert.Equal(t, leaf.cert.Raw, res.PeerCertificates[0].Raw)
+
+		conn.Close()
+		_, ok = ConnResult(conn)
+		assert.False(t, ok, "should be forgotten after close")
+	})
+	t.Run("fingerprintInvalid", func(t *testing.T) {
+		d, err := NewDialer(SkipTLSVerify(), FingerprintSHA1("81f344a7686a80b4c5293e8fdc0b0160c82c06a8"))
+		require.NoError(t, err)
+		_, err = d.DialContext(context.Background(), "tcp", addr)
+		assert.ErrorIs(t, err, ErrNotMatchedFingerprint)
+	})
+	t.Run("zero", func(t *testing.T) {
+		_, err := (&Dialer{}).DialContext(context.Background(), "tcp", addr)
+		assert.Error(t, err)
+		_, err = (&Dialer{Config: &tls.Config{ServerName: "localhost"}}).DialContext(context.Background(), "tcp", addr)
+		var authErr x509.UnknownAuthorityError
+		assert.True(t, errors.As(err, &authErr), "got %v", err)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(leaf.cert)
+		conn, err := (&Dialer{Config: &tls.Config{RootCAs: roots, ServerName: "localhost"}}).DialContext(context.Background(), "tcp", addr)
+		require.NoError(t, err)
+		conn.Close()
+	})
+	t.Run("invalidOptions", func(t *testing.T) {
+		_, err := NewDialer(DANE(StaticTLSAResolver{}, "localhost", 0))
+		assert.Error(t, err)
+	})
+}
+
+func TestTLSConfig(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, root, "localhost")
+	addr := serveTLS(t, leaf.tlsCertificate())
+	dane := DANE(StaticTLSAResolver{
+		"localhost:443": {{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingFull, root.cert.Raw}},
+	}, "localhost", 443)
+
+	t.Run("ok", func(t *testing.T) {
+		cfg, err := TLSConfig(dane)
+		require.NoError(t, err)
+		cfg.ServerName = "localhost"
+		conn, err := tls.Dial("tcp", addr, cfg)
+		require.NoError(t, err)
+		conn.Close()
+	})
+	t.Run("wrongServerName", func(t *testing.T) {
+		cfg, err := TLSConfig(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()), DANE(StaticTLSAResolver{
+			"example.com:443": {{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingFull, root.cert.Raw}},
+		}, "", 443))
+		require.NoError(t, err)
+		cfg.ServerName = "example.com"
+		_, err = tls.Dial("tcp", addr, cfg)
+		assert.Error(t, err)
+	})
+	t.Run("invalidOptions", func(t *testing.T) {
+		_, err := TLSConfig(DANE(nil, "localhost", 0), dane, DANE(StaticTLSAResolver{}, "localhost", -1))
+		assert.Error(t, err)
+	})
+}
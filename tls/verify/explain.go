@@ -0,0 +1,310 @@
+package verify
+
+import (
+	"crypto/x509"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VerificationError is the error of the failed check with the details for Explain.
+// The Error() is the message of Err and errors.Is/As see through it.
+type VerificationError struct {
+	Err error
+	// ServerName is the checked name of the server.
+	ServerName string
+	// KnownPins are the expected pins in the form "algorithm/value".
+	KnownPins []string
+	// Cert is the cert the error is about, the leaf by default.
+	Cert *x509.Certificate
+	// Result is nil if the presented certs are not parsed.
+	Result *Result
+	// Chain is the presented chain if CaptureChain is set.
+	Chain *CapturedChain
+}
+
+func (e *VerificationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// newVerificationError wraps err with the details of the verification.
+func newVerificationError(err error, opts *Options, res *Result, chain *CapturedChain) *VerificationError {
+	e, ok := err.(*VerificationError)
+	if !ok {
+		e = &VerificationError{Err: err}
+	}
+	e.ServerName = opts.DNSName
+	e.KnownPins = opts.knownPins()
+	e.Result = res
+	e.Chain = chain
+	if e.Cert == nil && res != nil {
+		e.Cert = res.PeerCertificates[0]
+	}
+	return e
+}
+
+// now is replaced in the tests.
+var now = time.Now
+
+// Explain returns the human-readable explanation of the error of the verifier
+// (or of the client that uses it) in full sentences.
+func Explain(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dialErr *DialError
+	if errors.As(err, &dialErr) {
+		msg := dialErr.Message()
+		if explained := explainVerification(dialErr.Err); explained != "" {
+			msg += ": " + explained
+		}
+		return sentence(msg)
+	}
+	if explained := explainVerification(err); explained != "" {
+		return sentence(explained)
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return sentence(fmt.Sprintf("request %s %s failed: %v", urlErr.Op, urlErr.URL, urlErr.Err))
+	}
+	return sentence(err.Error())
+}
+
+// explainVerification explains the error of the checks, it returns "" for other errors.
+func explainVerification(err error) string {
+	var (
+		verr      *VerificationError
+		hostErr   x509.HostnameError
+		matchErr  *MatchError
+		svidErr   *SVIDError
+		authErr   x509.UnknownAuthorityError
+		invalidEr x509.CertificateInvalidError
+	)
+	errors.As(err, &verr)
+	if verr == nil {
+		verr = &VerificationError{}
+	}
+	cert := verr.Cert
+	name := verr.ServerName
+	if name == "" && cert != nil {
+		name = cert.Subject.CommonName
+	}
+
+	var anyErr *AnyError
+	if errors.As(err, &anyErr) {
+		reasons := make([]string, len(anyErr.Errs))
+		for i, e := range anyErr.Errs {
+			// the errors of the checks have no context of the verifier
+			inner := &VerificationError{Err: e, ServerName: verr.ServerName, KnownPins: verr.KnownPins, Cert: cert, Result: verr.Result}
+			var innerVerr *VerificationError
+			if errors.As(e, &innerVerr) && innerVerr.Cert != nil {
+				inner.Cert = innerVerr.Cert
+			}
+			if reasons[i] = explainVerification(inner); reasons[i] == "" {
+				reasons[i] = e.Error()
+			}
+		}
+		return "none of the accepted checks passed: " + strings.Join(reasons, "; ")
+	}
+
+	switch {
+	case errors.Is(err, ErrCheckPassed):
+		return fmt.Sprintf("certificate %s passed the check that it should fail", certName(cert, name))
+
+	case errors.Is(err, ErrCertExpired) && cert != nil:
+		certDesc := certName(cert, name)
+		// the expired cert may be the intermediate of the chain
+		if res := verr.Result; res != nil && len(res.PeerCertificates) > 0 && !res.PeerCertificates[0].Equal(cert) {
+			certDesc = fmt.Sprintf("%q in the chain %s", cert.Subject.String(), certName(res.PeerCertificates[0], name))
+		}
+		t := now()
+		if t.Before(cert.NotBefore) {
+			return fmt.Sprintf("certificate %s is not valid yet, it becomes valid %s (%s), issued by %s",
+				certDesc, relTime(cert.NotBefore.Sub(t)), formatTime(cert.NotBefore), issuerName(cert))
+		}
+		return fmt.Sprintf("certificate %s expired %s (%s), issued by %s",
+			certDesc, relTime(cert.NotAfter.Sub(t)), formatTime(cert.NotAfter), issuerName(cert))
+	case errors.Is(err, ErrCertExpired):
+		return "certificate expired or is not valid yet"
+
+	case errors.Is(err, ErrNotMatchedFingerprint), errors.Is(err, ErrNotMatchedPin):
+		pinned := "fingerprint"
+		if errors.Is(err, ErrNotMatchedPin) {
+			pinned = "public key"
+		}
+		msg := fmt.Sprintf("certificate %s does not match the pinned %s", certName(cert, name), pinned)
+		if len(verr.KnownPins) > 0 {
+			msg += fmt.Sprintf(", expected %s", strings.Join(verr.KnownPins, " or "))
+		}
+		if cert != nil {
+			f := CertFingerprints(cert)
+			msg += fmt.Sprintf(", seen sha1/%s (sha256/%s, subject %q, issued by %s)", f.SHA1, f.SPKISHA256, cert.Subject.String(), issuerName(cert))
+		}
+		return msg
+
+	case errors.As(err, &matchErr):
+		rejected := make([]string, len(matchErr.Rejected))
+		for i, m := range matchErr.Rejected {
+			seen := "none"
+			if values := m.Values(matchErr.Cert); len(values) > 0 {
+				seen = fmt.Sprintf("%q", values)
+			}
+			rejected[i] = fmt.Sprintf("%s (seen %s)", m, seen)
+		}
+		return fmt.Sprintf("certificate %s is rejected by the identity matchers: %s", certName(matchErr.Cert, name), strings.Join(rejected, ", "))
+
+	case errors.As(err, &svidErr):
+		return fmt.Sprintf("certificate %s is not a valid SPIFFE X.509-SVID: %s", certName(svidErr.Cert, name), svidErr.Reason)
+	case errors.Is(err, ErrUnknownTrustDomain):
+		msg := fmt.Sprintf("there is no SPIFFE trust bundle for the trust domain of certificate %s", certName(cert, name))
+		if cert != nil {
+			if id, err := SPIFFEIDFromCert(cert); err == nil {
+				msg += fmt.Sprintf(" (%s)", id)
+			}
+		}
+		return msg
+
+	case errors.As(err, &hostErr):
+		return fmt.Sprintf("certificate is not valid for %s, %s", hostErr.Host, certNames(hostErr.Certificate))
+
+	case errors.As(err, &authErr):
+		c := authErr.Cert
+		if c == nil {
+			c = cert
+		}
+		if c == nil {
+			return "certificate is signed by an unknown authority"
+		}
+		if c.Subject.String() == c.Issuer.String() {
+			if cert != nil && !c.Equal(cert) {
+				return fmt.Sprintf("certificate %s is issued by the untrusted root %q, add it to the roots or pin the certificate",
+					certName(cert, name), c.Subject.CommonName)
+			}
+			return fmt.Sprintf("certificate %s is self-signed and not trusted, add it to the roots or pin it", certName(c, name))
+		}
+		return fmt.Sprintf("certificate %s is signed by an unknown authority %s, add the issuer to the roots or pin the certificate",
+			certName(c, name), issuerName(c))
+
+	case errors.As(err, &invalidEr):
+		return fmt.Sprintf("certificate %s is invalid: %s", certName(invalidEr.Cert, name), invalidReason(invalidEr))
+
+	case errors.Is(err, ErrNoUsableTLSA):
+		return fmt.Sprintf("there are no usable TLSA records for %s", name)
+	case errors.Is(err, ErrNotMatchedTLSA):
+		return fmt.Sprintf("no TLSA record for %s matches the presented chain", name)
+	}
+
+	if verr.Err != nil {
+		return fmt.Sprintf("verification of certificate %s failed: %v", certName(cert, name), verr.Err)
+	}
+	return ""
+}
+
+func invalidReason(err x509.CertificateInvalidError) string {
+	switch err.Reason {
+	case x509.NotAuthorizedToSign:
+		return "it is used as the issuer but it is not authorized to sign other certificates"
+	case x509.CANotAuthorizedForThisName:
+		return "the issuer is not authorized for the name by the name constraints"
+	case x509.TooManyIntermediates:
+		return "the chain has too many intermediates"
+	case x509.IncompatibleUsage:
+		return "the key usage does not allow the server authentication"
+	case x509.NameMismatch:
+		return "the issuer name does not match the subject of the parent"
+	}
+	if err.Detail != "" {
+		return err.Detail
+	}
+	return err.Error()
+}
+
+// certName returns "for <name>" (or the subject if name is empty).
+func certName(cert *x509.Certificate, name string) string {
+	if name != "" {
+		return "for " + name
+	}
+	if cert != nil {
+		return fmt.Sprintf("%q", cert.Subject.String())
+	}
+	return "of the server"
+}
+
+func issuerName(cert *x509.Certificate) string {
+	if cert.Issuer.CommonName != "" {
+		return fmt.Sprintf("%q", cert.Issuer.CommonName)
+	}
+	return fmt.Sprintf("%q", cert.Issuer.String())
+}
+
+// certNames describes the names the cert is valid for.
+func certNames(cert *x509.Certificate) string {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("it has no subject alternative names (CN %q is not used for the names)", cert.Subject.CommonName)
+	}
+	return "it is valid for " + strings.Join(names, ", ")
+}
+
+// relTime returns "3 days ago" for the negative and "in 3 days" for the positive duration.
+func relTime(d time.Duration) string {
+	past := d < 0
+	if past {
+		d = -d
+	}
+
+	var n int64
+	var unit string
+	switch {
+	case d >= 24*time.Hour:
+		n, unit = int64(math.Round(d.Hours()/24)), "day"
+	case d >= time.Hour:
+		n, unit = int64(math.Round(d.Hours())), "hour"
+	case d >= time.Minute:
+		n, unit = int64(math.Round(d.Minutes())), "minute"
+	default:
+		return "just now"
+	}
+	s := fmt.Sprintf("%d %s", n, unit)
+	if n != 1 {
+		s += "s"
+	}
+	if past {
+		return s + " ago"
+	}
+	return "in " + s
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 MST")
+}
+
+// sentence capitalizes the first letter and adds the period.
+func sentence(s string) string {
+	if s == "" {
+		return s
+	}
+	s = strings.ToUpper(s[:1]) + s[1:]
+	if !strings.HasSuffix(s, ".") {
+		s += "."
+	}
+	return s
+}
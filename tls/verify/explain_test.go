@@ -0,0 +1,135 @@
+package verify
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	notAfter := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	root := newTestCA(t, "Test Root", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	intermediate := newTestCA(t, "Test Intermediate", root)
+	leaf := newTestLeaf(t, root, "api.example.com", "www.example.com")
+	expired := issueTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "api.example.com"},
+		DNSNames:  []string{"api.example.com"},
+		NotBefore: notAfter.Add(-time.Hour),
+		NotAfter:  notAfter,
+	}, root)
+	expiredIntermediate := issueTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Expired Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotBefore:             notAfter.Add(-time.Hour),
+		NotAfter:              notAfter,
+	}, root)
+	future := issueTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "api.example.com"},
+		DNSNames:  []string{"api.example.com"},
+		NotBefore: time.Now().Add(2 * time.Hour).Truncate(time.Minute),
+		NotAfter:  time.Now().Add(3 * time.Hour),
+	}, root)
+	selfSigned := newTestLeaf(t, nil, "api.example.com")
+	const fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
+
+	verify := func(chain [][]byte, opts ...Option) error {
+		return TLSVerifyPeerCertificate(opts...).Option()(chain, nil)
+	}
+	seen := `seen sha1/` + leaf.sha1() + ` (sha256/` + CertFingerprints(leaf.cert).SPKISHA256 + `, subject "CN=api.example.com", issued by "Test Root")`
+
+	tests := []struct {
+		name string
+		now  time.Time
+		err  error
+		want string
+	}{
+		{"nil", time.Now(), nil, ""},
+		{
+			"expired",
+			notAfter.Add(3 * 24 * time.Hour),
+			verify(rawChain(expired, root), RootCAs(roots), DNSName("api.example.com")),
+			`Certificate for api.example.com expired 3 days ago (2026-10-16 12:00 UTC), issued by "Test Root".`,
+		},
+		{
+			"expiredIntermediate",
+			notAfter.Add(3 * 24 * time.Hour),
+			verify(rawChain(newTestLeaf(t, expiredIntermediate, "api.example.com"), expiredIntermediate), RootCAs(roots), DNSName("api.example.com")),
+			`Certificate "CN=Expired Intermediate" in the chain for api.example.com expired 3 days ago (2026-10-16 12:00 UTC), issued by "Test Root".`,
+		},
+		{
+			"notYetValid",
+			future.cert.NotBefore.Add(-2 * time.Hour),
+			verify(rawChain(future, root), RootCAs(roots), DNSName("api.example.com")),
+			`Certificate for api.example.com is not valid yet, it becomes valid in 2 hours (` + formatTime(future.cert.NotBefore) + `), issued by "Test Root".`,
+		},
+		{
+			"fingerprint",
+			time.Now(),
+			verify(rawChain(leaf, root), SkipTLSVerify(), DNSName("api.example.com"), FingerprintSHA1(fingerprintNoRegistred)),
+			`Certificate for api.example.com does not match the pinned fingerprint, expected sha1/` + fingerprintNoRegistred + `, ` + seen + `.`,
+		},
+		{
+			"hostname",
+			time.Now(),
+			verify(rawChain(leaf, root), RootCAs(roots), DNSName("example.org")),
+			`Certificate is not valid for example.org, it is valid for api.example.com, www.example.com.`,
+		},
+		{
+			"untrustedRoot",
+			time.Now(),
+			verify(rawChain(leaf, root), DNSName("api.example.com")),
+			`Certificate for api.example.com is issued by the untrusted root "Test Root", add it to the roots or pin the certificate.`,
+		},
+		{
+			"unknownAuthority",
+			time.Now(),
+			verify(rawChain(newTestLeaf(t, intermediate, "api.example.com")), RootCAs(roots), DNSName("api.example.com")),
+			`Certificate for api.example.com is signed by an unknown authority "Test Intermediate", add the issuer to the roots or pin the certificate.`,
+		},
+		{
+			"selfSigned",
+			time.Now(),
+			verify(rawChain(selfSigned), DNSName("api.example.com")),
+			`Certificate for api.example.com is self-signed and not trusted, add it to the roots or pin it.`,
+		},
+		{
+			"dial",
+			time.Now(),
+			&DialError{Addr: "api.example.com:443", Kind: ErrNotMatchedFingerprint, Err: verify(rawChain(leaf, root), SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred))},
+			`Certificate of api.example.com:443 does not match the pinned fingerprint: certificate for api.example.com does not match the pinned fingerprint, expected sha1/` +
+				fingerprintNoRegistred + `, ` + seen + `.`,
+		},
+		{"other", time.Now(), errors.New("connection reset"), "Connection reset."},
+	}
+	defer func(orig func() time.Time) { now = orig }(now)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = func() time.Time { return tt.now }
+			assert.Equal(t, tt.want, Explain(tt.err))
+		})
+	}
+}
+
+func TestRelTime(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-3 * 24 * time.Hour, "3 days ago"},
+		{-25 * time.Hour, "1 day ago"},
+		{2 * time.Hour, "in 2 hours"},
+		{time.Minute, "in 1 minute"},
+		{-10 * time.Second, "just now"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, relTime(tt.d), tt.d.String())
+	}
+}
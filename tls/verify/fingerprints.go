@@ -0,0 +1,46 @@
+package verify
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Fingerprints are the fingerprints of the cert in all supported algorithms.
+type Fingerprints struct {
+	// SHA1, SHA256 and SHA512 are the hex of the hash of the DER cert.
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+	// SPKISHA256 is the base64 of the SHA-256 of the public key (RFC 7469 pin).
+	// It is empty if the cert is not parsed.
+	SPKISHA256 string `json:"spki_sha256,omitempty"`
+}
+
+// CertFingerprints returns the fingerprints of the cert.
+func CertFingerprints(cert *x509.Certificate) Fingerprints {
+	f := rawFingerprints(cert.Raw)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	f.SPKISHA256 = base64.StdEncoding.EncodeToString(spki[:])
+	return f
+}
+
+// rawFingerprints returns the fingerprints of the DER cert without parsing.
+func rawFingerprints(der []byte) Fingerprints {
+	sha1Sum := sha1.Sum(der)
+	sha256Sum := sha256.Sum256(der)
+	sha512Sum := sha512.Sum512(der)
+	f := Fingerprints{
+		SHA1:   hex.EncodeToString(sha1Sum[:]),
+		SHA256: hex.EncodeToString(sha256Sum[:]),
+		SHA512: hex.EncodeToString(sha512Sum[:]),
+	}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		f.SPKISHA256 = base64.StdEncoding.EncodeToString(spki[:])
+	}
+	return f
+}
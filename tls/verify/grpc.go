@@ -0,0 +1,98 @@
+package verify
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+)
+
+var errServerHandshakeNotSupported = errors.New("server handshake is not supported")
+
+// GRPCCredentials returns the client transport credentials that verify
+// the server cert like TLSVerifyPeerCertificate.
+// The host of the dialed target (or the overridden server name) is checked
+// if the DNSName option is not set.
+// The invalid options fail each handshake.
+//
+// Deprecated: use NewGRPCCredentials, it rejects the invalid options.
+func GRPCCredentials(opts ...Option) credentials.TransportCredentials {
+	return &grpcCredentials{
+		v: TLSVerifyPeerCertificate(opts...),
+	}
+}
+
+// NewGRPCCredentials returns the credentials like GRPCCredentials or the error of the invalid options.
+//
+//	creds, err := verify.NewGRPCCredentials(opts...)
+//	if err != nil {
+//		return err
+//	}
+//	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+func NewGRPCCredentials(opts ...Option) (credentials.TransportCredentials, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcCredentials{v: v}, nil
+}
+
+// GRPCAuthInfo is the AuthInfo of the connection verified by GRPCCredentials.
+type GRPCAuthInfo struct {
+	credentials.TLSInfo
+	Result *Result
+}
+
+type grpcCredentials struct {
+	v          *tlsVerifyPeerCertificate
+	serverName string
+}
+
+func (c *grpcCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	serverName := c.serverName
+	if serverName == "" {
+		serverName = hostname(authority)
+	}
+
+	var res *Result
+	conn := tls.Client(rawConn, c.v.withContext(ctx).tlsConfig(contextWithAddr(ctx, authority), &tls.Config{NextProtos: []string{"h2"}}, serverName, func(r *Result, err error) {
+		if err == nil {
+			res = r
+		}
+	}))
+	if err := handshake(ctx, conn); err != nil {
+		return nil, nil, err
+	}
+
+	return conn, GRPCAuthInfo{
+		TLSInfo: credentials.TLSInfo{
+			State:          conn.ConnectionState(),
+			CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		},
+		Result: res,
+	}, nil
+}
+
+func (c *grpcCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errServerHandshakeNotSupported
+}
+
+func (c *grpcCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       c.serverName,
+	}
+}
+
+func (c *grpcCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *grpcCredentials) OverrideServerName(serverNameOverride string) error {
+	c.serverName = serverNameOverride
+	return nil
+}
@@ -0,0 +1,227 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	ErrConnRefused      = errors.New("connection refused")
+	ErrConnTimeout      = errors.New("connection timeout")
+	ErrHostNotResolved  = errors.New("host not resolved")
+	ErrUnknownAuthority = errors.New("certificate signed by unknown authority")
+	ErrConnUnknown      = errors.New("failed to connect")
+)
+
+var dialErrorMessages = map[error]string{
+	ErrConnRefused:           "connection refused by %s, check that the server is running and the port is correct",
+	ErrConnTimeout:           "connection to %s timed out, check that the address is reachable",
+	ErrHostNotResolved:       "failed to resolve host of %s, check the host name",
+	ErrCertExpired:           "certificate of %s has expired",
+	ErrNotMatchedFingerprint: "certificate of %s does not match the pinned fingerprint",
+	ErrNotMatchedPin:         "certificate of %s does not match the pinned public key",
+	ErrNotMatchedIdentity:    "certificate of %s does not match the allowed identities",
+	ErrUnknownAuthority:      "certificate of %s is signed by an unknown authority",
+	ErrConnUnknown:           "failed to connect to %s",
+}
+
+// DialError is the classified error of DialGRPC.
+// The errors.Is reports true for one of the ErrConnRefused, ErrConnTimeout,
+// ErrHostNotResolved, ErrCertExpired, ErrNotMatchedFingerprint, ErrNotMatchedPin,
+// ErrNotMatchedIdentity, ErrUnknownAuthority or ErrConnUnknown.
+type DialError struct {
+	Addr string
+	Kind error
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("dial %s: %v: %v", e.Addr, e.Kind, e.Err)
+}
+
+// Message returns the human-readable message. The message is stable for the kind of error.
+func (e *DialError) Message() string {
+	return fmt.Sprintf(dialErrorMessages[e.Kind], e.Addr)
+}
+
+func (e *DialError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCDialOption sets the options of DialGRPC.
+type GRPCDialOption func(opts *grpcDialOptions)
+
+type grpcDialOptions struct {
+	PlainText    bool
+	VerifyOpts   []Option
+	GRPCDialOpts []grpc.DialOption
+	Dialer       func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// GRPCPlainText dials without TLS.
+func GRPCPlainText() GRPCDialOption {
+	return func(opts *grpcDialOptions) {
+		opts.PlainText = true
+	}
+}
+
+// GRPCVerify adds the options of the verifier of the server cert.
+func GRPCVerify(verifyOpts ...Option) GRPCDialOption {
+	return func(opts *grpcDialOptions) {
+		opts.VerifyOpts = append(opts.VerifyOpts, verifyOpts...)
+	}
+}
+
+// GRPCDialOptions adds the standard gRPC dial options.
+// The dialer of grpc.WithContextDialer is replaced by the one of DialGRPC, use GRPCDialer instead.
+func GRPCDialOptions(grpcOpts ...grpc.DialOption) GRPCDialOption {
+	return func(opts *grpcDialOptions) {
+		opts.GRPCDialOpts = append(opts.GRPCDialOpts, grpcOpts...)
+	}
+}
+
+// GRPCDialer sets the dialer of the underlying connection, the zero net.Dialer is used by default.
+// The errors of the dialer are classified by DialGRPC.
+func GRPCDialer(dialer func(ctx context.Context, addr string) (net.Conn, error)) GRPCDialOption {
+	return func(opts *grpcDialOptions) {
+		opts.Dialer = dialer
+	}
+}
+
+// DialGRPC dials to addr and blocks until the connection is ready or timeout.
+// On failure it returns the human-readable message and the *DialError.
+// The invalid verify options fail before dialing with their error.
+func DialGRPC(ctx context.Context, addr string, timeout time.Duration, opts ...GRPCDialOption) (*grpc.ClientConn, string, error) {
+	o := &grpcDialOptions{}
+	for _, set := range opts {
+		set(o)
+	}
+
+	dialer := o.Dialer
+	if dialer == nil {
+		dialer = func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}
+	}
+	last := &lastError{}
+	dialOpts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+	}
+	if o.PlainText {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	} else {
+		creds, err := NewGRPCCredentials(o.VerifyOpts...)
+		if err != nil {
+			return nil, err.Error(), err
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(&lastErrorCredentials{
+			TransportCredentials: creds,
+			last:                 last,
+		}))
+	}
+	dialOpts = append(dialOpts, o.GRPCDialOpts...)
+	// the dialer is the last option to keep the errors of the dial, the options of the user do not replace it
+	dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dialer(ctx, addr)
+		last.set(err)
+		return conn, err
+	}))
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, dialOpts...)
+	if err == nil {
+		return conn, "", nil
+	}
+
+	cause := last.get()
+	if cause == nil {
+		cause = err
+	}
+	dialErr := &DialError{Addr: addr, Kind: classifyDialError(cause), Err: cause}
+	return nil, dialErr.Message(), dialErr
+}
+
+func classifyDialError(err error) error {
+	var (
+		dnsErr  *net.DNSError
+		authErr x509.UnknownAuthorityError
+		netErr  net.Error
+	)
+	switch {
+	case errors.Is(err, ErrCertExpired):
+		return ErrCertExpired
+	case errors.Is(err, ErrNotMatchedFingerprint):
+		return ErrNotMatchedFingerprint
+	case errors.Is(err, ErrNotMatchedPin):
+		return ErrNotMatchedPin
+	case errors.Is(err, ErrNotMatchedIdentity), errors.Is(err, ErrInvalidSVID):
+		return ErrNotMatchedIdentity
+	case errors.As(err, &authErr), errors.Is(err, ErrUnknownTrustDomain):
+		return ErrUnknownAuthority
+	case errors.As(err, &dnsErr):
+		return ErrHostNotResolved
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrConnRefused
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrConnTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrConnTimeout
+	}
+	return ErrConnUnknown
+}
+
+// lastError keeps the last error of the dial attempts,
+// because the gRPC returns only the deadline error after retries.
+type lastError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (l *lastError) set(err error) {
+	if err == nil {
+		return
+	}
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+}
+
+func (l *lastError) get() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+type lastErrorCredentials struct {
+	credentials.TransportCredentials
+	last *lastError
+}
+
+func (c *lastErrorCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	c.last.set(err)
+	return conn, info, err
+}
+
+func (c *lastErrorCredentials) Clone() credentials.TransportCredentials {
+	return &lastErrorCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		last:                 c.last,
+	}
+}
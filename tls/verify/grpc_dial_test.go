@@ -0,0 +1,115 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestDialGRPC(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	addrOK := serveGRPC(t, leaf)
+	addrExpired := serveGRPC(t, issueTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "localhost"},
+		NotBefore: time.Now().Add(-2 * time.Hour),
+		NotAfter:  time.Now().Add(-time.Hour),
+	}, nil))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addrRefused := lis.Addr().String()
+	lis.Close()
+
+	// accepts but never answers the handshake
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silent.Close()
+
+	tests := []struct {
+		name string
+		addr string
+		opts []GRPCDialOption
+		want error
+	}{
+		{"plainText-refused", addrRefused, []GRPCDialOption{GRPCPlainText()}, ErrConnRefused},
+		{"tls-refused", addrRefused, nil, ErrConnRefused},
+		{"tls-timeout", silent.Addr().String(), nil, ErrConnTimeout},
+		{"unknownAuthority", addrOK, []GRPCDialOption{GRPCVerify(DNSName("localhost"))}, ErrUnknownAuthority},
+		{"fingerprintInvalid", addrOK, []GRPCDialOption{GRPCVerify(SkipTLSVerify(), FingerprintSHA1("81f344a7686a80b4c5293e8fdc0b0160c82c06a8"))}, ErrNotMatchedFingerprint},
+		{"expired", addrExpired, []GRPCDialOption{GRPCVerify(DNSName("localhost"))}, ErrCertExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, msg, err := DialGRPC(context.Background(), tt.addr, 500*time.Millisecond, tt.opts...)
+			assert.Nil(t, conn)
+			assert.True(t, errors.Is(err, tt.want), "got %v", err)
+			assert.Equal(t, (&DialError{Addr: tt.addr, Kind: tt.want}).Message(), msg)
+		})
+	}
+
+	t.Run("invalidPin", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer lis.Close()
+		accepted := make(chan struct{}, 1)
+		go func() {
+			if conn, err := lis.Accept(); err == nil {
+				accepted <- struct{}{}
+				conn.Close()
+			}
+		}()
+
+		conn, _, err := DialGRPC(context.Background(), lis.Addr().String(), time.Second, GRPCVerify(SkipTLSVerify(), PinSHA256("sha256/abc")))
+		assert.Nil(t, conn)
+		var fingerprintErr *FingerprintError
+		assert.True(t, errors.As(err, &fingerprintErr), "got %v", err)
+		select {
+		case <-accepted:
+			t.Fatal("the invalid pin should be rejected before the connection")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("dialer", func(t *testing.T) {
+		var dialed int32
+		dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dialed, 1)
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}
+		conn, _, err := DialGRPC(context.Background(), addrOK, time.Second, GRPCVerify(SkipTLSVerify()), GRPCDialer(dialer))
+		require.NoError(t, err)
+		conn.Close()
+		assert.NotZero(t, atomic.LoadInt32(&dialed))
+
+		// the errors of the dialer are classified
+		opts := []GRPCDialOption{GRPCPlainText(), GRPCDialer(dialer)}
+		_, _, err = DialGRPC(context.Background(), addrRefused, 500*time.Millisecond, opts...)
+		assert.True(t, errors.Is(err, ErrConnRefused), "got %v", err)
+
+		// the dialer of the gRPC options does not replace the classified one
+		_, _, err = DialGRPC(context.Background(), addrRefused, 500*time.Millisecond, GRPCPlainText(), GRPCDialOptions(grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return nil, errors.New("should not be called")
+		})))
+		assert.True(t, errors.Is(err, ErrConnRefused), "got %v", err)
+	})
+	t.Run("ok", func(t *testing.T) {
+		conn, msg, err := DialGRPC(context.Background(), addrOK, time.Second, GRPCVerify(SkipTLSVerify()))
+		require.NoError(t, err)
+		assert.Empty(t, msg)
+		conn.Close()
+	})
+}
+
+func Test_classifyDialError(t *testing.T) {
+	assert.Equal(t, ErrHostNotResolved, classifyDialError(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.invalid"}}))
+	assert.Equal(t, ErrConnUnknown, classifyDialError(errors.New("some error")))
+}
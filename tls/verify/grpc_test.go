@@ -0,0 +1,106 @@
+package verify
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2/pkg/crypto/fingerprint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+func serveGRPC(t *testing.T, leaf *testCert) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewServerTLSFromCert(&tls.Certificate{
+		Certificate: [][]byte{leaf.cert.Raw},
+		PrivateKey:  leaf.key,
+	})))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestGRPCCredentials(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	addr := serveGRPC(t, leaf)
+	sha1hex, err := fingerprint.Fingerprint(leaf.cert, crypto.SHA1)
+	require.NoError(t, err)
+
+	dial := func(creds credentials.TransportCredentials) (*grpc.ClientConn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	}
+
+	t.Run("fingerprintOK", func(t *testing.T) {
+		conn, err := dial(GRPCCredentials(SkipTLSVerify(), FingerprintSHA1(sha1hex)))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var p peer.Peer
+		_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Peer(&p))
+		require.NoError(t, err)
+		info, ok := p.AuthInfo.(GRPCAuthInfo)
+		require.True(t, ok)
+		assert.Equal(t, "tls", info.AuthType())
+		require.NotNil(t, info.Result)
+		assert.Equal(t, leaf.cert.Raw, info.Result.PeerCertificates[0].Raw)
+	})
+	t.Run("fingerprintInvalid", func(t *testing.T) {
+		_, err := dial(GRPCCredentials(SkipTLSVerify(), FingerprintSHA1("81f344a7686a80b4c5293e8fdc0b0160c82c06a8")))
+		assert.Error(t, err)
+	})
+	t.Run("unknownAuthority", func(t *testing.T) {
+		_, err := dial(GRPCCredentials())
+		assert.Error(t, err)
+	})
+}
+
+func TestGRPCCredentials_OverrideServerName(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, root, "example.com")
+
+	handshake := func(creds credentials.TransportCredentials) (credentials.AuthInfo, error) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go func() {
+			defer server.Close()
+			srv := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{{
+				Certificate: [][]byte{leaf.cert.Raw},
+				PrivateKey:  leaf.key,
+			}}})
+			srv.Handshake()
+		}()
+		_, info, err := creds.ClientHandshake(context.Background(), "127.0.0.1:443", client)
+		return info, err
+	}
+
+	creds := GRPCCredentials(DANE(StaticTLSAResolver{
+		"example.com:443": {{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingFull, root.cert.Raw}},
+	}, "", 443))
+
+	_, err := handshake(creds)
+	assert.Error(t, err, "should be verified for the authority host")
+
+	clone := creds.Clone()
+	require.NoError(t, clone.OverrideServerName("example.com"))
+	assert.Equal(t, "example.com", clone.Info().ServerName)
+	assert.Empty(t, creds.Info().ServerName, "original should not be changed")
+
+	info, err := handshake(clone)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", info.(GRPCAuthInfo).State.ServerName)
+}
@@ -1,10 +1,163 @@
 package verify
 
-import "strings"
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
 
-func normalHex(in string) string {
-	if strings.Contains(in, ":") {
-		in = strings.Join(strings.Split(in, ":"), "")
+// Fingerprint is the parsed fingerprint of the cert or the pin of the public key.
+type Fingerprint struct {
+	// Algorithm is one of "sha1", "sha256" or "sha512".
+	Algorithm string
+	Sum       []byte
+}
+
+// Hex returns the fingerprint in lower case hex.
+func (f Fingerprint) Hex() string {
+	return hex.EncodeToString(f.Sum)
+}
+
+// Base64 returns the fingerprint in base64.
+func (f Fingerprint) Base64() string {
+	return base64.StdEncoding.EncodeToString(f.Sum)
+}
+
+// FingerprintError is the error of the malformed fingerprint.
+type FingerprintError struct {
+	Input  string
+	Reason string
+}
+
+func (e *FingerprintError) Error() string {
+	return fmt.Sprintf("invalid fingerprint %q: %s", e.Input, e.Reason)
+}
+
+var hashSizes = map[string]int{
+	"sha1":   sha1.Size,
+	"sha256": sha256.Size,
+	"sha512": sha512.Size,
+}
+
+// ParseFingerprint parses the fingerprint in one of the forms:
+//
+//	7e12499cecec22de53787179bf28d4512d662396           hex
+//	7E:12:49:9C:EC:EC:22:DE:53:78:71:79:BF:28:D4:51:2D:66:23:96 colon hex
+//	SHA1 Fingerprint=7E:12:49:...                      openssl x509 -fingerprint
+//	sha-256 AB:CD:...                                  RFC 4572
+//	sha256/q4PO2G2cbkZhZ82+JgmRUyGMoAeozA+BSXVXQWB8XWQ= algorithm/base64 (RFC 7469 pin)
+//	q4PO2G2cbkZhZ82+JgmRUyGMoAeozA+BSXVXQWB8XWQ=        base64
+//
+// The algorithm is taken from the prefix or from the size of the hash.
+func ParseFingerprint(s string) (Fingerprint, error) {
+	fail := func(reason string, args ...interface{}) (Fingerprint, error) {
+		return Fingerprint{}, &FingerprintError{Input: s, Reason: fmt.Sprintf(reason, args...)}
+	}
+
+	var algorithm string
+	value := strings.TrimSpace(s)
+	if i := strings.Index(value, "="); i > 0 && strings.HasSuffix(strings.ToLower(value[:i]), " fingerprint") {
+		// openssl: "SHA1 Fingerprint=AA:BB:..."
+		algorithm, value = value[:i-len(" fingerprint")], value[i+1:]
+	} else if i := strings.Index(value, " "); i > 0 {
+		// RFC 4572: "sha-256 AB:CD:..."
+		algorithm, value = value[:i], strings.TrimSpace(value[i+1:])
+	} else if i := strings.Index(value, "/"); i > 0 && strings.HasPrefix(strings.ToLower(value), "sha") {
+		// RFC 7469: "sha256/<base64>"
+		algorithm, value = value[:i], value[i+1:]
+	}
+	if algorithm != "" {
+		algorithm = strings.Replace(strings.ToLower(algorithm), "-", "", 1)
+		if _, ok := hashSizes[algorithm]; !ok {
+			return fail("unsupported algorithm %q", algorithm)
+		}
+	}
+	if value == "" {
+		return fail("empty")
+	}
+
+	sum, err := decodeFingerprint(value)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if algorithm == "" {
+		for name, size := range hashSizes {
+			if size == len(sum) {
+				algorithm = name
+			}
+		}
+		if algorithm == "" {
+			return fail("unexpected length %d bytes", len(sum))
+		}
+	} else if len(sum) != hashSizes[algorithm] {
+		return fail("unexpected length %d bytes for %s", len(sum), algorithm)
+	}
+	return Fingerprint{Algorithm: algorithm, Sum: sum}, nil
+}
+
+// decodeFingerprint decodes the colon hex, the hex or the base64.
+func decodeFingerprint(value string) ([]byte, error) {
+	if strings.Contains(value, ":") {
+		octets := strings.Split(value, ":")
+		sum := make([]byte, len(octets))
+		for i, octet := range octets {
+			if len(octet) != 2 {
+				return nil, fmt.Errorf("octet %d %q is not two hex digits", i+1, octet)
+			}
+			b, err := hex.DecodeString(octet)
+			if err != nil {
+				return nil, fmt.Errorf("octet %d %q is not hex", i+1, octet)
+			}
+			sum[i] = b[0]
+		}
+		return sum, nil
+	}
+	if isHex(value) {
+		if len(value)%2 != 0 {
+			return nil, fmt.Errorf("odd number of hex digits")
+		}
+		return hex.DecodeString(value)
+	}
+	// the length of the hex of the hash is not the length of the base64 of any hash
+	for _, size := range hashSizes {
+		if len(value) == 2*size {
+			for i, c := range value {
+				if !isHex(string(c)) {
+					return nil, fmt.Errorf("invalid hex digit %q at %d", c, i+1)
+				}
+			}
+		}
+	}
+	if sum, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return sum, nil
+	}
+	if sum, err := base64.RawStdEncoding.DecodeString(value); err == nil {
+		return sum, nil
+	}
+	return nil, fmt.Errorf("neither hex nor base64")
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFingerprintOf parses the fingerprint of the algorithm.
+func parseFingerprintOf(algorithm, s string) ([]byte, error) {
+	f, err := ParseFingerprint(s)
+	if err != nil {
+		return nil, err
+	}
+	if f.Algorithm != algorithm {
+		return nil, &FingerprintError{Input: s, Reason: fmt.Sprintf("%s instead of %s", f.Algorithm, algorithm)}
 	}
-	return strings.ToLower(in)
+	return f.Sum, nil
 }
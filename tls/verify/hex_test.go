@@ -1,23 +1,71 @@
 package verify
 
-import "testing"
+import (
+	"testing"
 
-func Test_normalHex(t *testing.T) {
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFingerprint(t *testing.T) {
+	const (
+		sha1Hex   = "7e12499cecec22de53787179bf28d4512d662396"
+		sha256B64 = "q4PO2G2cbkZhZ82+JgmRUyGMoAeozA+BSXVXQWB8XWQ="
+		sha256Hex = "ab83ced86d9c6e466167cdbe26099153218ca007a8cc0f8149755741607c5d64"
+	)
 	tests := []struct {
-		in   string
-		want string
+		in        string
+		algorithm string
+		hex       string
+		wantErr   string
 	}{
-		{},
-		{"abc", "abc"},
-		{"7E:12:49:9C:EC:EC:22:DE:53:78:71:79:BF:28:D4:51:2D:66:23:96", "7e12499cecec22de53787179bf28d4512d662396"},
-		{"7E12499CECEC22DE53787179BF28D4512D662396", "7e12499cecec22de53787179bf28d4512d662396"},
-		{"7e12499cecec22de53787179bf28d4512d662396", "7e12499cecec22de53787179bf28d4512d662396"},
+		{"7E:12:49:9C:EC:EC:22:DE:53:78:71:79:BF:28:D4:51:2D:66:23:96", "sha1", sha1Hex, ""},
+		{"7E12499CECEC22DE53787179BF28D4512D662396", "sha1", sha1Hex, ""},
+		{sha1Hex, "sha1", sha1Hex, ""},
+		{"SHA1 Fingerprint=7E:12:49:9C:EC:EC:22:DE:53:78:71:79:BF:28:D4:51:2D:66:23:96\n", "sha1", sha1Hex, ""},
+		{"sha1/" + sha1Hex, "sha1", sha1Hex, ""},
+		{"sha-1 7E:12:49:9C:EC:EC:22:DE:53:78:71:79:BF:28:D4:51:2D:66:23:96", "sha1", sha1Hex, ""},
+		{"sha256/" + sha256B64, "sha256", sha256Hex, ""},
+		{sha256B64, "sha256", sha256Hex, ""},
+		{"sha-256 AB:83:CE:D8:6D:9C:6E:46:61:67:CD:BE:26:09:91:53:21:8C:A0:07:A8:CC:0F:81:49:75:57:41:60:7C:5D:64", "sha256", sha256Hex, ""},
+		{"SHA256 Fingerprint=AB:83:CE:D8:6D:9C:6E:46:61:67:CD:BE:26:09:91:53:21:8C:A0:07:A8:CC:0F:81:49:75:57:41:60:7C:5D:64", "sha256", sha256Hex, ""},
+
+		{"", "", "", `invalid fingerprint "": empty`},
+		{"abc", "", "", `invalid fingerprint "abc": odd number of hex digits`},
+		{"abcd", "", "", `invalid fingerprint "abcd": unexpected length 2 bytes`},
+		{"7e12499cecec22de53787179bf28d4512d6623zz", "", "", `invalid fingerprint "7e12499cecec22de53787179bf28d4512d6623zz": invalid hex digit 'z' at 39`},
+		{"ab83ced86d9c6e466167cdbe26099153218ca007a8cc0f81497557416O7c5d64", "", "", `invalid fingerprint "ab83ced86d9c6e466167cdbe26099153218ca007a8cc0f81497557416O7c5d64": invalid hex digit 'O' at 58`},
+		{"7E:12:49:9C:EC:EC:22:DE:53:78:71:79:BF:28:D4:51:2D:66:23", "", "", `invalid fingerprint "7E:12:49:9C:EC:EC:22:DE:53:78:71:79:BF:28:D4:51:2D:66:23": unexpected length 19 bytes`},
+		{"7E:12:4:9C", "", "", `invalid fingerprint "7E:12:4:9C": octet 3 "4" is not two hex digits`},
+		{"7E:12:ZZ:9C", "", "", `invalid fingerprint "7E:12:ZZ:9C": octet 3 "ZZ" is not hex`},
+		{"sha256/" + sha1Hex, "", "", `invalid fingerprint "sha256/` + sha1Hex + `": unexpected length 20 bytes for sha256`},
+		{"7e12499c!", "", "", `invalid fingerprint "7e12499c!": neither hex nor base64`},
+		{"MD5 Fingerprint=AA:BB", "", "", `invalid fingerprint "MD5 Fingerprint=AA:BB": unsupported algorithm "md5"`},
+		{"sha-384 AB:CD", "", "", `invalid fingerprint "sha-384 AB:CD": unsupported algorithm "sha384"`},
 	}
 	for _, tt := range tests {
 		t.Run(tt.in, func(t *testing.T) {
-			if got := normalHex(tt.in); got != tt.want {
-				t.Errorf("normalHex() = %v, want %v", got, tt.want)
+			got, err := ParseFingerprint(tt.in)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
 			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.algorithm, got.Algorithm)
+			assert.Equal(t, tt.hex, got.Hex())
 		})
 	}
 }
+
+func TestFingerprintSHA1_malformed(t *testing.T) {
+	_, err := TLSConfig(FingerprintSHA1("abc"))
+	assert.EqualError(t, err, `invalid fingerprint "abc": odd number of hex digits`)
+	_, err = NewDialer(PinSHA256("sha256/"+"q4PO2G2cbkZhZ82+JgmRUyGMoAeozA+BSXVXQWB8XWQ="), FingerprintSHA1("sha256/q4PO2G2cbkZhZ82+JgmRUyGMoAeozA+BSXVXQWB8XWQ="))
+	assert.EqualError(t, err, `invalid fingerprint "sha256/q4PO2G2cbkZhZ82+JgmRUyGMoAeozA+BSXVXQWB8XWQ=": sha256 instead of sha1`)
+
+	// the verifier without the construction error fails each verification
+	leaf := newTestLeaf(t, nil, "localhost")
+	v := TLSVerifyPeerCertificate(SkipTLSVerify(), FingerprintSHA1("abc"))
+	assert.EqualError(t, v.Option()(rawChain(leaf), nil), `invalid fingerprint "abc": odd number of hex digits`)
+	assert.Error(t, v.Update(PinSHA256("abc")))
+}
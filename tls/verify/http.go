@@ -1,18 +1,485 @@
 package verify
 
 import (
+	"container/list"
+	"context"
 	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
 )
 
-func HttpClient(opts ...tlsVerifyPeerCertificateOption) *http.Client {
+// HttpClient returns the client with the transport based on the http.DefaultTransport
+// that verifies the server certs (see RoundTripper).
+// The invalid options fail each request.
+//
+// Deprecated: use NewHttpClient, it rejects the invalid options.
+func HttpClient(opts ...Option) *http.Client {
 	v := TLSVerifyPeerCertificate(opts...)
 	return &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify:    true,
-				VerifyPeerCertificate: v.Option(),
-			},
+		Transport: v.roundTripper(nil),
+	}
+}
+
+// NewHttpClient returns the client like HttpClient or the error of the invalid options,
+// e.g. the malformed fingerprint or pin.
+func NewHttpClient(opts ...Option) (*http.Client, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: v.roundTripper(nil),
+	}, nil
+}
+
+// WrapTransport returns the clone of base with the verification of the server certs.
+// The TLS config of base is kept (see TLSConfig) and HTTP/2 stays enabled
+// unless it is disabled in base by the non-nil empty TLSNextProto.
+// The TLS connections are dialed by the DialContext of base, DialTLSContext of base is replaced.
+// If base is nil the http.DefaultTransport is used.
+func WrapTransport(base *http.Transport, opts ...Option) (*http.Transport, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return v.wrapTransport(base), nil
+}
+
+func (v *tlsVerifyPeerCertificate) wrapTransport(base *http.Transport) *http.Transport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := base.Clone()
+	t.TLSClientConfig = v.tlsConfig(context.Background(), base.TLSClientConfig, "", nil)
+	// the custom TLS config disables HTTP/2 unless forced
+	if t.TLSNextProto == nil || len(t.TLSNextProto) > 0 {
+		t.ForceAttemptHTTP2 = true
+	}
+
+	// the TLS connections are dialed by the clone to pass the dialed address to the observers,
+	// the transport dials the HTTPS proxy (not the target) by DialTLSContext if the request is proxied
+	var httpsProxies sync.Map
+	if base.Proxy != nil {
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			proxyURL, err := base.Proxy(req)
+			if err == nil && proxyURL != nil && proxyURL.Scheme == "https" {
+				httpsProxies.Store(proxyAddr(proxyURL), struct{}{})
+			}
+			return proxyURL, err
+		}
+	}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		rawConn, err := dialTransport(ctx, base, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		_, proxied := httpsProxies.Load(addr)
+		return v.transportTLS(ctx, base, t, rawConn, addr, proxied)
+	}
+	return t
+}
+
+// proxyAddr returns the address of the proxy dialed by the transport.
+func proxyAddr(proxyURL *url.URL) string {
+	port := proxyURL.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// RoundTripper is the http.RoundTripper that verifies the server certs.
+// The result of the verification of the connection that served the response
+// is available by ResultFromResponse.
+// The requests with the options in the context (see ContextWithOptions)
+// are sent by the separate transports, one per the options of the context.
+// The transports of the least recently used options are closed
+// if there are more than maxPolicies of them.
+type RoundTripper struct {
+	v    *tlsVerifyPeerCertificate
+	base *http.Transport
+
+	mu        sync.Mutex
+	transport *trackedTransport
+	policies  map[contextPolicy]*list.Element // of *policyTransport
+	lru       *list.List                      // the most recently used first
+}
+
+// maxPolicies is the limit of the transports of the context options.
+const maxPolicies = 32
+
+// policyTransport is the RoundTripper of the context options.
+type policyTransport struct {
+	key contextPolicy
+	rt  *RoundTripper
+	// opts keeps the options of the context, so the key is not reused while it is cached
+	opts []Option
+}
+
+// NewRoundTripper returns the RoundTripper over the clone of base (see WrapTransport).
+func NewRoundTripper(base *http.Transport, opts ...Option) (*RoundTripper, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return v.roundTripper(base), nil
+}
+
+func (v *tlsVerifyPeerCertificate) roundTripper(base *http.Transport) *RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	rt := &RoundTripper{
+		v:    v,
+		base: base.Clone(),
+	}
+	rt.transport = rt.newTransport()
+	return rt
+}
+
+func (rt *RoundTripper) newTransport() *trackedTransport {
+	t := &trackedTransport{Transport: rt.v.wrapTransport(rt.base), conns: make(map[*trackedConn]struct{})}
+	// NOTE: the connections to the targets over the proxies are verified
+	// by the TLS config of the transport, without the result.
+	// The connections to the HTTPS proxies are dialed by DialTLSContext,
+	// they are verified by the base TLS config (see transportTLS).
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return rt.dialTLS(ctx, t, network, addr)
+	}
+	return t
+}
+
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the transport of the context options may be retired (by Update or the eviction)
+	// after it is picked, then the request is sent by the transport picked again
+	for {
+		policy := rt.forContext(req.Context())
+		if t := policy.acquire(); t != nil {
+			return policy.roundTrip(req, t)
+		}
+	}
+}
+
+// acquire counts the request on the current transport of rt.
+// It returns nil if the transport is retired, it happens
+// for the retired RoundTripper of the context options only.
+func (rt *RoundTripper) acquire() *trackedTransport {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if !rt.transport.acquire() {
+		return nil
+	}
+	return rt.transport
+}
+
+// forContext returns the RoundTripper for the options of the context.
+func (rt *RoundTripper) forContext(ctx context.Context) *RoundTripper {
+	key, ok := policyFromContext(ctx)
+	if !ok {
+		return rt
+	}
+
+	rt.mu.Lock()
+	if elem, ok := rt.policies[key]; ok {
+		rt.lru.MoveToFront(elem)
+		rt.mu.Unlock()
+		return elem.Value.(*policyTransport).rt
+	}
+	if rt.policies == nil {
+		rt.policies = make(map[contextPolicy]*list.Element)
+		rt.lru = list.New()
+	}
+	policy := &policyTransport{
+		key:  key,
+		rt:   rt.v.withContext(ctx).roundTripper(rt.base),
+		opts: optionsFromContext(ctx),
+	}
+	rt.policies[key] = rt.lru.PushFront(policy)
+	var evicted *policyTransport
+	if rt.lru.Len() > maxPolicies {
+		evicted = rt.lru.Remove(rt.lru.Back()).(*policyTransport)
+		delete(rt.policies, evicted.key)
+	}
+	rt.mu.Unlock()
+
+	if evicted != nil {
+		evicted.rt.retire()
+	}
+	return policy.rt
+}
+
+// roundTrip sends the request by t acquired for it.
+func (rt *RoundTripper) roundTrip(req *http.Request, t *trackedTransport) (*http.Response, error) {
+	holder := &resultHolder{}
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if conn, ok := info.Conn.(*tls.Conn); ok {
+				holder.res, _ = ConnResult(conn)
+			}
 		},
+	})
+	ctx = context.WithValue(ctx, resultHolderKey{}, holder)
+	if t.Proxy != nil {
+		if proxyURL, err := t.Proxy(req); err == nil && proxyURL != nil && proxyURL.Scheme == "https" {
+			ctx = context.WithValue(ctx, httpsProxyKey{}, true)
+		}
+	}
+	res, err := t.RoundTrip(req.WithContext(ctx))
+	// the switched connection is not returned to the pool
+	if err != nil || res.StatusCode == http.StatusSwitchingProtocols {
+		t.release()
+		return res, err
+	}
+	res.Body = &releaseBody{ReadCloser: res.Body, release: t.release}
+	return res, nil
+}
+
+// Update replaces the options of the verifier by the new ones.
+// The new connections are used for the requests after the update: the idle
+// connections verified by the previous options are closed and the connections
+// in use are closed after their requests (when the bodies of the responses are closed).
+// It is safe to call concurrently with the requests.
+func (rt *RoundTripper) Update(opts ...Option) error {
+	if err := rt.v.Update(opts...); err != nil {
+		return err
+	}
+
+	rt.mu.Lock()
+	transport, policies := rt.transport, rt.policyTransports()
+	rt.transport, rt.policies, rt.lru = rt.newTransport(), nil, nil
+	rt.mu.Unlock()
+
+	transport.retire()
+	for _, policy := range policies {
+		policy.retire()
+	}
+	return nil
+}
+
+// retire closes the connections of the transports of rt after the requests in flight.
+func (rt *RoundTripper) retire() {
+	rt.mu.Lock()
+	transport, policies := rt.transport, rt.policyTransports()
+	rt.mu.Unlock()
+
+	transport.retire()
+	for _, policy := range policies {
+		policy.retire()
+	}
+}
+
+// UpdateClient updates the options of the client built by HttpClient (see RoundTripper.Update).
+func UpdateClient(c *http.Client, opts ...Option) error {
+	rt, ok := c.Transport.(*RoundTripper)
+	if !ok {
+		return errors.New("the client transport is not verify.RoundTripper")
+	}
+	return rt.Update(opts...)
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transports.
+func (rt *RoundTripper) CloseIdleConnections() {
+	rt.mu.Lock()
+	transport, policies := rt.transport, rt.policyTransports()
+	rt.mu.Unlock()
+
+	transport.CloseIdleConnections()
+	for _, policy := range policies {
+		policy.CloseIdleConnections()
+	}
+}
+
+// policyTransports returns the RoundTripper of the context options, rt.mu should be held.
+func (rt *RoundTripper) policyTransports() []*RoundTripper {
+	policies := make([]*RoundTripper, 0, len(rt.policies))
+	for _, elem := range rt.policies {
+		policies = append(policies, elem.Value.(*policyTransport).rt)
+	}
+	return policies
+}
+
+func (rt *RoundTripper) dialTLS(ctx context.Context, t *trackedTransport, network, addr string) (net.Conn, error) {
+	rawConn, err := dialTransport(ctx, rt.base, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	proxied, _ := ctx.Value(httpsProxyKey{}).(bool)
+	return rt.v.transportTLS(ctx, rt.base, t.Transport, t.track(rawConn), addr, proxied)
+}
+
+// dialTransport dials the underlying connection of the TLS connection of the transport.
+func dialTransport(ctx context.Context, base *http.Transport, network, addr string) (net.Conn, error) {
+	if base.DialContext != nil {
+		return base.DialContext(ctx, network, addr)
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+// transportTLS runs the handshake over the connection dialed by DialTLSContext of t, the wrapped base.
+// The target is verified by v and the HTTPS proxy (if proxied) by the base TLS config,
+// the target is verified after CONNECT by the TLS config of t.
+func (v *tlsVerifyPeerCertificate) transportTLS(ctx context.Context, base, t *http.Transport, rawConn net.Conn, addr string, proxied bool) (net.Conn, error) {
+	cfg := &tls.Config{}
+	if base.TLSClientConfig != nil {
+		cfg = base.TLSClientConfig.Clone()
+	}
+	if base.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, base.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	if proxied {
+		cfg.ServerName = hostname(addr)
+		cfg.NextProtos = nil
+		conn := tls.Client(rawConn, cfg)
+		if err := handshake(ctx, conn); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	// the transport sets the ALPN protocols (h2) into its TLS config
+	cfg.NextProtos = t.TLSClientConfig.NextProtos
+
+	// the options of the context are already applied to v
+	d := &Dialer{Config: cfg, v: v}
+	conn, err := d.client(ctx, v, rawConn, addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// trackedTransport counts the requests in flight to close the connections
+// of the retired transport after them. Otherwise the connections in use are
+// returned to the idle pool of the transport that is not used anymore.
+type trackedTransport struct {
+	*http.Transport
+
+	mu       sync.Mutex
+	inflight int
+	retired  bool
+	conns    map[*trackedConn]struct{}
+}
+
+// acquire counts the request. It returns false if the transport is retired,
+// the request should not be sent by it.
+func (t *trackedTransport) acquire() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.retired {
+		return false
+	}
+	t.inflight++
+	return true
+}
+
+func (t *trackedTransport) release() {
+	t.mu.Lock()
+	t.inflight--
+	done := t.retired && t.inflight == 0
+	t.mu.Unlock()
+	if done {
+		t.closeAll()
+	}
+}
+
+// retire closes the idle connections now and the rest after the requests in flight.
+func (t *trackedTransport) retire() {
+	t.mu.Lock()
+	t.retired = true
+	done := t.inflight == 0
+	t.mu.Unlock()
+	t.CloseIdleConnections()
+	if done {
+		t.closeAll()
+	}
+}
+
+// closeAll closes the connections dialed by the transport, there are no requests on them.
+// The connection is returned to the idle pool after the response body is closed,
+// so the idle connections may be missed by CloseIdleConnections.
+func (t *trackedTransport) closeAll() {
+	t.CloseIdleConnections()
+	t.mu.Lock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for conn := range t.conns {
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func (t *trackedTransport) track(conn net.Conn) net.Conn {
+	tc := &trackedConn{Conn: conn, t: t}
+	t.mu.Lock()
+	t.conns[tc] = struct{}{}
+	t.mu.Unlock()
+	return tc
+}
+
+// trackedConn is the connection dialed by the trackedTransport.
+type trackedConn struct {
+	net.Conn
+	t    *trackedTransport
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.t.mu.Lock()
+		delete(c.t.conns, c)
+		c.t.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// releaseBody releases the request of the trackedTransport when the body is closed or read to the end.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+type resultHolderKey struct{}
+
+// httpsProxyKey marks the context of the request sent over the HTTPS proxy.
+type httpsProxyKey struct{}
+
+type resultHolder struct {
+	res *Result
+}
+
+// ResultFromResponse returns the result of the verification of the connection
+// that served the response received by the RoundTripper (or the HttpClient).
+func ResultFromResponse(res *http.Response) (*Result, bool) {
+	if res == nil || res.Request == nil {
+		return nil, false
+	}
+	holder, ok := res.Request.Context().Value(resultHolderKey{}).(*resultHolder)
+	if !ok || holder.res == nil {
+		return nil, false
 	}
+	return holder.res, true
 }
@@ -0,0 +1,246 @@
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveHTTPS serves "ok" over HTTP/2 with the cert.
+func serveHTTPS(t *testing.T, cert tls.Certificate) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.EnableHTTP2 = true
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, c *http.Client, url string) (*http.Response, error) {
+	t.Helper()
+
+	res, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(dat))
+	return res, nil
+}
+
+func TestWrapTransport(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, root, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+	addr := "https://localhost:" + strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	var baseVerified bool
+	base := &http.Transport{
+		Proxy:               func(*http.Request) (*url.URL, error) { return nil, nil },
+		TLSHandshakeTimeout: 3 * time.Second,
+		MaxIdleConnsPerHost: 7,
+		TLSClientConfig: &tls.Config{
+			RootCAs: roots,
+			VerifyConnection: func(tls.ConnectionState) error {
+				baseVerified = true
+				return nil
+			},
+		},
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		tr, err := WrapTransport(base, FingerprintSHA1(leaf.sha1()))
+		require.NoError(t, err)
+		assert.NotNil(t, tr.Proxy)
+		assert.Equal(t, 3*time.Second, tr.TLSHandshakeTimeout)
+		assert.Equal(t, 7, tr.MaxIdleConnsPerHost)
+		assert.Same(t, roots, tr.TLSClientConfig.RootCAs)
+
+		res, err := get(t, &http.Client{Transport: tr}, addr)
+		require.NoError(t, err)
+		assert.Equal(t, 2, res.ProtoMajor)
+		assert.True(t, baseVerified)
+		assert.Nil(t, base.TLSClientConfig.VerifyPeerCertificate)
+		assert.False(t, base.TLSClientConfig.InsecureSkipVerify, "base should not be changed")
+	})
+	t.Run("fingerprintInvalid", func(t *testing.T) {
+		tr, err := WrapTransport(base, FingerprintSHA1("81f344a7686a80b4c5293e8fdc0b0160c82c06a8"))
+		require.NoError(t, err)
+		_, err = get(t, &http.Client{Transport: tr}, addr)
+		assert.True(t, errors.Is(err, ErrNotMatchedFingerprint), "got %v", err)
+	})
+	t.Run("unknownAuthority", func(t *testing.T) {
+		tr, err := WrapTransport(nil)
+		require.NoError(t, err)
+		_, err = get(t, &http.Client{Transport: tr}, addr)
+		assert.True(t, errors.As(err, &x509.UnknownAuthorityError{}), "got %v", err)
+	})
+	t.Run("http1", func(t *testing.T) {
+		h1 := base.Clone()
+		h1.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		tr, err := WrapTransport(h1)
+		require.NoError(t, err)
+		res, err := get(t, &http.Client{Transport: tr}, addr)
+		require.NoError(t, err)
+		assert.Equal(t, 1, res.ProtoMajor)
+	})
+}
+
+func TestHttpClient(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+
+	res, err := get(t, HttpClient(SkipTLSVerify(), FingerprintSHA1(leaf.sha1())), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.ProtoMajor)
+
+	_, err = get(t, HttpClient(SkipTLSVerify(), FingerprintSHA1("81f344a7686a80b4c5293e8fdc0b0160c82c06a8")), srv.URL)
+	assert.True(t, errors.Is(err, ErrNotMatchedFingerprint), "got %v", err)
+}
+
+func TestNewHttpClient(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+
+	c, err := NewHttpClient(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+	require.NoError(t, err)
+	_, err = get(t, c, srv.URL)
+	require.NoError(t, err)
+
+	for _, opt := range []Option{FingerprintSHA1("abc"), PinSHA256("sha256/abc")} {
+		c, err := NewHttpClient(SkipTLSVerify(), opt)
+		assert.Nil(t, c)
+		var fingerprintErr *FingerprintError
+		assert.True(t, errors.As(err, &fingerprintErr), "got %v", err)
+
+		_, err = NewTLSVerifyPeerCertificate(SkipTLSVerify(), opt)
+		assert.True(t, errors.As(err, &fingerprintErr), "got %v", err)
+	}
+}
+
+func TestResultFromResponse(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, root, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate(root))
+	addr := "https://localhost:" + strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	rt, err := NewRoundTripper(&http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}, FingerprintSHA1(leaf.sha1()))
+	require.NoError(t, err)
+	c := &http.Client{Transport: rt}
+
+	for i := 0; i < 2; i++ {
+		res, err := get(t, c, addr)
+		require.NoError(t, err)
+		assert.Equal(t, 2, res.ProtoMajor)
+
+		got, ok := ResultFromResponse(res)
+		require.True(t, ok, "request %d", i)
+		assert.Equal(t, leaf.sha1(), got.FingerprintSHA1)
+		assert.Equal(t, "CN=localhost", got.Subject)
+		assert.Equal(t, "CN=root", got.Issuer)
+		assert.Equal(t, leaf.cert.NotAfter, got.NotAfter)
+		assert.Equal(t, []string{CheckChain, CheckHostname, CheckFingerprint}, got.Checks)
+		assert.Len(t, got.VerifiedChains, 1)
+	}
+
+	t.Run("HttpClient", func(t *testing.T) {
+		res, err := get(t, HttpClient(SkipTLSVerify()), srv.URL)
+		require.NoError(t, err)
+		got, ok := ResultFromResponse(res)
+		require.True(t, ok)
+		assert.Empty(t, got.Checks)
+		assert.Len(t, got.PeerCertificates, 2)
+	})
+	t.Run("notVerifiedResponse", func(t *testing.T) {
+		_, ok := ResultFromResponse(&http.Response{Request: &http.Request{}})
+		assert.False(t, ok)
+	})
+}
+
+// serveHTTPSProxy serves the CONNECT proxy over TLS with the cert.
+func serveHTTPSProxy(t *testing.T, cert tls.Certificate) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRoundTripper_httpsProxy(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	srv := serveHTTPS(t, leaf.tlsCertificate())
+
+	proxyRoot := newTestCA(t, "proxy root", nil)
+	proxy := serveHTTPSProxy(t, newTestLeaf(t, proxyRoot, "localhost").tlsCertificate())
+	proxyURL, err := url.Parse("https://localhost:" + strconv.Itoa(proxy.Listener.Addr().(*net.TCPAddr).Port))
+	require.NoError(t, err)
+	proxyRoots := x509.NewCertPool()
+	proxyRoots.AddCert(proxyRoot.cert)
+
+	newClient := func(t *testing.T, fingerprint string) *http.Client {
+		rt, err := NewRoundTripper(&http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: proxyRoots},
+		}, SkipTLSVerify(), FingerprintSHA1(fingerprint))
+		require.NoError(t, err)
+		return &http.Client{Transport: rt}
+	}
+
+	// the proxy is verified by the base TLS config, the pin is of the target
+	_, err = get(t, newClient(t, leaf.sha1()), srv.URL)
+	require.NoError(t, err)
+
+	_, err = get(t, newClient(t, "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"), srv.URL)
+	assert.True(t, errors.Is(err, ErrNotMatchedFingerprint), "got %v", err)
+
+	t.Run("untrustedProxy", func(t *testing.T) {
+		rt, err := NewRoundTripper(&http.Transport{Proxy: http.ProxyURL(proxyURL)}, SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+		require.NoError(t, err)
+		_, err = get(t, &http.Client{Transport: rt}, srv.URL)
+		var authErr x509.UnknownAuthorityError
+		assert.True(t, errors.As(err, &authErr), "got %v", err)
+	})
+}
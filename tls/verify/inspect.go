@@ -0,0 +1,196 @@
+package verify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Report is the outcome of the inspection of the endpoint (see Inspect).
+type Report struct {
+	Addr       string `json:"addr"`
+	ServerName string `json:"server_name"`
+
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	// ALPN is the negotiated application protocol, it is empty if not negotiated.
+	ALPN string `json:"alpn,omitempty"`
+	// Certificates is the chain presented by the server, leaf first.
+	Certificates []CertificateReport `json:"certificates"`
+
+	// Decision is what the verification with the options would decide.
+	Decision Decision `json:"decision"`
+}
+
+// CertificateReport describes the cert of the chain.
+type CertificateReport struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	SerialNumber   string    `json:"serial_number"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	IsCA           bool      `json:"is_ca"`
+	// KeyType is one of RSA, ECDSA, Ed25519 or unknown.
+	KeyType            string `json:"key_type"`
+	KeySize            int    `json:"key_size"`
+	SignatureAlgorithm string `json:"signature_algorithm"`
+
+	Fingerprints Fingerprints `json:"fingerprints"`
+	// SPKIPin is the pin in the form "sha256/<base64>".
+	SPKIPin string `json:"spki_pin"`
+
+	Certificate *x509.Certificate `json:"-"`
+}
+
+// Decision is the decision of the verification.
+type Decision struct {
+	// Allowed reports whether the handshake would succeed.
+	// It is true in the report-only mode even with the violations.
+	Allowed bool `json:"allowed"`
+	// Checks are the names of the passed checks.
+	Checks []string `json:"checks,omitempty"`
+	// Failures are the explanations of Err and Violations (see Explain).
+	Failures []string `json:"failures,omitempty"`
+
+	// Err is the error that would fail the handshake.
+	Err error `json:"-"`
+	// Violations are the errors of the failed checks in the report-only mode.
+	Violations []error `json:"-"`
+}
+
+// Inspect connects to the address and reports the negotiated connection,
+// the presented chain and what the verification with the options would decide.
+// The handshake is not failed by the verification, so the report is returned
+// for the endpoints that do not pass it (the error is about the connection only).
+// DNSName (or the host of addr) is sent as the server name and checked.
+// The options of the context are applied and the observers are notified.
+func Inspect(ctx context.Context, addr string, opts ...Option) (*Report, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	v = v.withContext(ctx)
+
+	o := v.options().forServerName(hostname(addr))
+	report := &Report{
+		Addr:       addr,
+		ServerName: o.DNSName,
+	}
+
+	var (
+		res       *Result
+		verifyErr error
+	)
+	cfg := &tls.Config{
+		ServerName:         o.DNSName,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			rawCerts := make([][]byte, len(cs.PeerCertificates))
+			for i, cert := range cs.PeerCertificates {
+				rawCerts[i] = cert.Raw
+			}
+			res, verifyErr = v.verify(contextWithAddr(ctx, addr), o, rawCerts)
+			return nil
+		},
+	}
+
+	rawConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed connect")
+	}
+	conn := tls.Client(rawConn, cfg)
+	if err := handshake(ctx, conn); err != nil {
+		return nil, errors.Wrap(err, "failed handshake")
+	}
+	defer conn.Close()
+
+	cs := conn.ConnectionState()
+	report.Version = tlsVersionName(cs.Version)
+	report.CipherSuite = tls.CipherSuiteName(cs.CipherSuite)
+	report.ALPN = cs.NegotiatedProtocol
+	for _, cert := range cs.PeerCertificates {
+		report.Certificates = append(report.Certificates, newCertificateReport(cert))
+	}
+
+	report.Decision = Decision{
+		Allowed: verifyErr == nil,
+		Err:     verifyErr,
+	}
+	if verifyErr != nil {
+		report.Decision.Failures = append(report.Decision.Failures, Explain(verifyErr))
+	}
+	if res != nil {
+		report.Decision.Checks = res.Checks
+		report.Decision.Violations = res.Violations
+		for _, violation := range res.Violations {
+			report.Decision.Failures = append(report.Decision.Failures, Explain(violation))
+		}
+	}
+	return report, nil
+}
+
+func newCertificateReport(cert *x509.Certificate) CertificateReport {
+	f := CertFingerprints(cert)
+	r := CertificateReport{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       fmt.Sprintf("%x", cert.SerialNumber),
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		IsCA:               cert.IsCA,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		Fingerprints:       f,
+		SPKIPin:            "sha256/" + f.SPKISHA256,
+		Certificate:        cert,
+	}
+	for _, ip := range cert.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		r.URIs = append(r.URIs, u.String())
+	}
+	r.KeyType, r.KeySize = publicKeyInfo(cert.PublicKey)
+	return r
+}
+
+// publicKeyInfo returns the type and the size in bits of the public key.
+func publicKeyInfo(key interface{}) (string, int) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return "unknown", 0
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
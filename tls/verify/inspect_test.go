@@ -0,0 +1,86 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := newTestLeaf(t, root, "localhost")
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	srv := serveHTTPS(t, leaf.tlsCertificate(root))
+	addr := srv.Listener.Addr().String()
+	const fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
+
+	t.Run("allowed", func(t *testing.T) {
+		report, err := Inspect(context.Background(), addr, RootCAs(roots), DNSName("localhost"))
+		require.NoError(t, err)
+
+		assert.Equal(t, addr, report.Addr)
+		assert.Equal(t, "localhost", report.ServerName)
+		assert.Equal(t, "TLS 1.3", report.Version)
+		assert.NotEmpty(t, report.CipherSuite)
+		assert.Equal(t, "h2", report.ALPN)
+
+		require.Len(t, report.Certificates, 2)
+		cert := report.Certificates[0]
+		assert.Equal(t, "CN=localhost", cert.Subject)
+		assert.Equal(t, "CN=root", cert.Issuer)
+		assert.Equal(t, []string{"localhost"}, cert.DNSNames)
+		assert.Equal(t, leaf.cert.NotAfter, cert.NotAfter)
+		assert.False(t, cert.IsCA)
+		assert.Equal(t, "ECDSA", cert.KeyType)
+		assert.Equal(t, 256, cert.KeySize)
+		assert.Equal(t, "ECDSA-SHA256", cert.SignatureAlgorithm)
+		assert.Equal(t, CertFingerprints(leaf.cert), cert.Fingerprints)
+		assert.Equal(t, "sha256/"+CertFingerprints(leaf.cert).SPKISHA256, cert.SPKIPin)
+		assert.Equal(t, root.cert.Raw, report.Certificates[1].Certificate.Raw)
+		assert.True(t, report.Certificates[1].IsCA)
+
+		assert.Equal(t, Decision{Allowed: true, Checks: []string{CheckChain, CheckHostname}}, report.Decision)
+	})
+	t.Run("denied", func(t *testing.T) {
+		report, err := Inspect(context.Background(), addr, SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred))
+		require.NoError(t, err)
+		require.Len(t, report.Certificates, 2)
+
+		assert.False(t, report.Decision.Allowed)
+		assert.ErrorIs(t, report.Decision.Err, ErrNotMatchedFingerprint)
+		assert.Equal(t, []string{Explain(report.Decision.Err)}, report.Decision.Failures)
+	})
+	t.Run("reportOnly", func(t *testing.T) {
+		report, err := Inspect(context.Background(), addr, SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred), ReportOnly())
+		require.NoError(t, err)
+
+		assert.True(t, report.Decision.Allowed)
+		require.Len(t, report.Decision.Violations, 1)
+		assert.ErrorIs(t, report.Decision.Violations[0], ErrNotMatchedFingerprint)
+		assert.Len(t, report.Decision.Failures, 1)
+	})
+	t.Run("contextOptions", func(t *testing.T) {
+		ctx := ContextWithOptions(context.Background(), FingerprintSHA1(leaf.sha1()))
+		report, err := Inspect(ctx, addr, SkipTLSVerify())
+		require.NoError(t, err)
+		assert.Equal(t, []string{CheckFingerprint}, report.Decision.Checks)
+	})
+	t.Run("connRefused", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closedAddr := lis.Addr().String()
+		lis.Close()
+
+		_, err = Inspect(context.Background(), closedAddr)
+		assert.Error(t, err)
+	})
+	t.Run("invalidOptions", func(t *testing.T) {
+		_, err := Inspect(context.Background(), addr, DANE(StaticTLSAResolver{}, "localhost", 0))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,243 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MatchType is how the StringMatcher matches the value.
+type MatchType string
+
+const (
+	MatchExact    MatchType = "exact"
+	MatchPrefix   MatchType = "prefix"
+	MatchSuffix   MatchType = "suffix"
+	MatchContains MatchType = "contains"
+	// MatchRegex matches the whole value by the RE2 expression.
+	MatchRegex MatchType = "regex"
+)
+
+// StringMatcher matches the string like the Envoy string matcher.
+// It is created by ExactMatch, PrefixMatch, SuffixMatch, ContainsMatch or RegexMatch.
+type StringMatcher struct {
+	Type  MatchType
+	Value string
+	// IgnoreCase is not applied to the regex, use (?i) in the expression.
+	IgnoreCase bool
+
+	re    *regexp.Regexp
+	reErr error
+}
+
+func ExactMatch(value string) StringMatcher {
+	return StringMatcher{Type: MatchExact, Value: value}
+}
+
+func PrefixMatch(prefix string) StringMatcher {
+	return StringMatcher{Type: MatchPrefix, Value: prefix}
+}
+
+func SuffixMatch(suffix string) StringMatcher {
+	return StringMatcher{Type: MatchSuffix, Value: suffix}
+}
+
+func ContainsMatch(substr string) StringMatcher {
+	return StringMatcher{Type: MatchContains, Value: substr}
+}
+
+// RegexMatch matches the whole value by the expression.
+// The invalid expression fails the verifier construction.
+func RegexMatch(expr string) StringMatcher {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	return StringMatcher{Type: MatchRegex, Value: expr, re: re, reErr: err}
+}
+
+// Fold returns the matcher that ignores the case.
+func (m StringMatcher) Fold() StringMatcher {
+	m.IgnoreCase = true
+	return m
+}
+
+// Match reports whether the value matches.
+func (m StringMatcher) Match(value string) bool {
+	want := m.Value
+	if m.IgnoreCase && m.Type != MatchRegex {
+		value, want = strings.ToLower(value), strings.ToLower(want)
+	}
+	switch m.Type {
+	case MatchExact:
+		return value == want
+	case MatchPrefix:
+		return strings.HasPrefix(value, want)
+	case MatchSuffix:
+		return strings.HasSuffix(value, want)
+	case MatchContains:
+		return strings.Contains(value, want)
+	case MatchRegex:
+		return m.re != nil && m.re.MatchString(value)
+	}
+	return false
+}
+
+func (m StringMatcher) validate() error {
+	switch m.Type {
+	case MatchExact, MatchPrefix, MatchSuffix, MatchContains:
+		return nil
+	case MatchRegex:
+		if m.re == nil && m.reErr == nil {
+			return errors.Errorf("regex matcher %q is not created by RegexMatch", m.Value)
+		}
+		return errors.Wrapf(m.reErr, "invalid regex %q", m.Value)
+	}
+	return errors.Errorf("unknown match type %q", m.Type)
+}
+
+func (m StringMatcher) String() string {
+	s := fmt.Sprintf("%s %q", m.Type, m.Value)
+	if m.IgnoreCase {
+		s += " ignoring case"
+	}
+	return s
+}
+
+// CertField is the identity attribute of the cert.
+type CertField string
+
+const (
+	FieldSubjectCN CertField = "subject_cn"
+	FieldSubjectO  CertField = "subject_o"
+	FieldSubjectOU CertField = "subject_ou"
+	// FieldIssuerDN is the issuer name in RFC 2253 form, e.g. "CN=Issuer,O=Example".
+	FieldIssuerDN CertField = "issuer_dn"
+	FieldDNSSAN   CertField = "dns_san"
+	FieldURISAN   CertField = "uri_san"
+	FieldEmailSAN CertField = "email_san"
+	FieldIPSAN    CertField = "ip_san"
+)
+
+// CertMatcher matches the field of the cert, the multi-valued field
+// (O, OU and SANs) matches if one of the values matches.
+type CertMatcher struct {
+	Field   CertField
+	Matcher StringMatcher
+}
+
+func SubjectCN(m StringMatcher) CertMatcher { return CertMatcher{Field: FieldSubjectCN, Matcher: m} }
+func SubjectO(m StringMatcher) CertMatcher  { return CertMatcher{Field: FieldSubjectO, Matcher: m} }
+func SubjectOU(m StringMatcher) CertMatcher { return CertMatcher{Field: FieldSubjectOU, Matcher: m} }
+func IssuerDN(m StringMatcher) CertMatcher  { return CertMatcher{Field: FieldIssuerDN, Matcher: m} }
+func DNSSAN(m StringMatcher) CertMatcher    { return CertMatcher{Field: FieldDNSSAN, Matcher: m} }
+func URISAN(m StringMatcher) CertMatcher    { return CertMatcher{Field: FieldURISAN, Matcher: m} }
+func EmailSAN(m StringMatcher) CertMatcher  { return CertMatcher{Field: FieldEmailSAN, Matcher: m} }
+
+// IPSAN matches the IP SANs in the canonical form, e.g. "10.0.0.1" or "2001:db8::1".
+func IPSAN(m StringMatcher) CertMatcher { return CertMatcher{Field: FieldIPSAN, Matcher: m} }
+
+// Values returns the values of the field of the cert.
+func (m CertMatcher) Values(cert *x509.Certificate) []string {
+	switch m.Field {
+	case FieldSubjectCN:
+		return []string{cert.Subject.CommonName}
+	case FieldSubjectO:
+		return cert.Subject.Organization
+	case FieldSubjectOU:
+		return cert.Subject.OrganizationalUnit
+	case FieldIssuerDN:
+		return []string{cert.Issuer.String()}
+	case FieldDNSSAN:
+		return cert.DNSNames
+	case FieldURISAN:
+		values := make([]string, len(cert.URIs))
+		for i, uri := range cert.URIs {
+			values[i] = uri.String()
+		}
+		return values
+	case FieldEmailSAN:
+		return cert.EmailAddresses
+	case FieldIPSAN:
+		values := make([]string, len(cert.IPAddresses))
+		for i, ip := range cert.IPAddresses {
+			values[i] = ip.String()
+		}
+		return values
+	}
+	return nil
+}
+
+// Match reports whether one of the values of the field matches.
+func (m CertMatcher) Match(cert *x509.Certificate) bool {
+	for _, value := range m.Values(cert) {
+		if m.Matcher.Match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m CertMatcher) validate() error {
+	switch m.Field {
+	case FieldSubjectCN, FieldSubjectO, FieldSubjectOU, FieldIssuerDN, FieldDNSSAN, FieldURISAN, FieldEmailSAN, FieldIPSAN:
+	default:
+		return errors.Errorf("unknown cert field %q", m.Field)
+	}
+	return errors.Wrapf(m.Matcher.validate(), "invalid %s matcher", m.Field)
+}
+
+func (m CertMatcher) String() string {
+	return string(m.Field) + " " + m.Matcher.String()
+}
+
+// MatchError is the error of the cert rejected by the matchers.
+// errors.Is reports true for ErrNotMatchedIdentity.
+type MatchError struct {
+	// Rejected are the matchers that rejected the cert.
+	Rejected []CertMatcher
+	Cert     *x509.Certificate
+}
+
+func (e *MatchError) Error() string {
+	rejected := make([]string, len(e.Rejected))
+	for i, m := range e.Rejected {
+		rejected[i] = m.String()
+	}
+	return fmt.Sprintf("%v: rejected by %s", ErrNotMatchedIdentity, strings.Join(rejected, ", "))
+}
+
+func (e *MatchError) Unwrap() error {
+	return ErrNotMatchedIdentity
+}
+
+// MatchCert verifies that the leaf matches one of the matchers.
+// The error is *MatchError.
+func MatchCert(matchers ...CertMatcher) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		for _, m := range matchers {
+			if err := m.validate(); err != nil {
+				return err
+			}
+		}
+		leaf := chain.Leaf()
+		for _, m := range matchers {
+			if m.Match(leaf) {
+				chain.passed = append(chain.passed, CheckIdentity)
+				return nil
+			}
+		}
+		return &VerificationError{Err: &MatchError{Rejected: matchers, Cert: leaf}, Cert: leaf}
+	})
+}
+
+// AllowedIdentities requires the leaf to match one of the matchers (like the Envoy SAN matchers).
+// The matchers of the several options are required all. They are checked
+// after the chain (or DANE) and the pins, the failure names the rejecting matchers.
+// The invalid matcher fails the verifier construction.
+func AllowedIdentities(matchers ...CertMatcher) Option {
+	return func(opts *Options) {
+		opts.Identities = append(opts.Identities, matchers)
+	}
+}
@@ -0,0 +1,122 @@
+package verify
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringMatcher(t *testing.T) {
+	cases := []struct {
+		matcher StringMatcher
+		value   string
+		want    bool
+	}{
+		{ExactMatch("web"), "web", true},
+		{ExactMatch("web"), "Web", false},
+		{ExactMatch("web").Fold(), "Web", true},
+		{PrefixMatch("spiffe://example.org/"), "spiffe://example.org/web", true},
+		{PrefixMatch("spiffe://example.org/"), "spiffe://example.com/web", false},
+		{SuffixMatch(".example.org"), "api.example.org", true},
+		{SuffixMatch(".example.org"), "example.org", false},
+		{SuffixMatch(".EXAMPLE.org").Fold(), "api.example.ORG", true},
+		{ContainsMatch("prod"), "api-prod-1", true},
+		{RegexMatch(`api-\d+`), "api-12", true},
+		{RegexMatch(`api-\d+`), "api-12.evil", false},
+		{RegexMatch(`a|b`), "ab", false},
+		{RegexMatch(`(?i)API`), "api", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.matcher.Match(c.value), "%s %q", c.matcher, c.value)
+	}
+}
+
+func TestAllowedIdentities(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	leaf := issueTestCert(t, &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "web",
+			Organization:       []string{"Example", "Acme"},
+			OrganizationalUnit: []string{"payments"},
+		},
+		DNSNames:       []string{"web.example.org"},
+		EmailAddresses: []string{"ops@example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/ns/prod/web"}},
+	}, root)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	accepted := []CertMatcher{
+		SubjectCN(ExactMatch("web")),
+		SubjectO(ExactMatch("Acme")),
+		SubjectOU(PrefixMatch("pay")),
+		IssuerDN(ExactMatch("CN=root")),
+		DNSSAN(SuffixMatch(".example.org")),
+		URISAN(PrefixMatch("spiffe://example.org/ns/prod/")),
+		EmailSAN(ExactMatch("ops@example.org")),
+		IPSAN(ExactMatch("10.0.0.1")),
+		IPSAN(RegexMatch(`2001:db8::\d+`)),
+	}
+	for _, m := range accepted {
+		v := TLSVerifyPeerCertificate(RootCAs(roots), AllowedIdentities(m))
+		assert.NoError(t, v.Option()(rawChain(leaf), nil), m.String())
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		var res *Result
+		v := TLSVerifyPeerCertificate(
+			RootCAs(roots),
+			AllowedIdentities(SubjectCN(ExactMatch("web"))),
+			AllowedIdentities(URISAN(PrefixMatch("spiffe://example.org/ns/dev/")), SubjectO(ExactMatch("Evil"))),
+			Observer(func(e Event) { res = e.Result }),
+		)
+		err := v.Option()(rawChain(leaf), nil)
+		assert.ErrorIs(t, err, ErrNotMatchedIdentity)
+		var matchErr *MatchError
+		require.True(t, errors.As(err, &matchErr))
+		assert.Equal(t, []CertMatcher{URISAN(PrefixMatch("spiffe://example.org/ns/dev/")), SubjectO(ExactMatch("Evil"))}, matchErr.Rejected)
+		assert.EqualError(t, err, `not matched identity: rejected by uri_san prefix "spiffe://example.org/ns/dev/", subject_o exact "Evil"`)
+		assert.Equal(t, `Certificate for web is rejected by the identity matchers: `+
+			`uri_san prefix "spiffe://example.org/ns/dev/" (seen ["spiffe://example.org/ns/prod/web"]), subject_o exact "Evil" (seen ["Acme" "Example"]).`,
+			Explain(err))
+		// the failed verification publishes no passed checks and verified chains
+		assert.Empty(t, res.Checks)
+		assert.Empty(t, res.VerifiedChains)
+	})
+	t.Run("chainFirst", func(t *testing.T) {
+		v := TLSVerifyPeerCertificate(AllowedIdentities(SubjectCN(ExactMatch("web"))))
+		err := v.Option()(rawChain(leaf), nil)
+		var authErr x509.UnknownAuthorityError
+		assert.True(t, errors.As(err, &authErr), "got %v", err)
+	})
+	t.Run("reportOnly", func(t *testing.T) {
+		var res *Result
+		v := TLSVerifyPeerCertificate(RootCAs(roots), ReportOnly(), AllowedIdentities(IPSAN(ExactMatch("10.0.0.2"))), Observer(func(e Event) { res = e.Result }))
+		require.NoError(t, v.Option()(rawChain(leaf), nil))
+		require.Len(t, res.Violations, 1)
+		assert.ErrorIs(t, res.Violations[0], ErrNotMatchedIdentity)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := TLSConfig(AllowedIdentities(SubjectCN(RegexMatch("("))))
+		assert.Error(t, err)
+		_, err = TLSConfig(AllowedIdentities())
+		assert.Error(t, err)
+		_, err = TLSConfig(AllowedIdentities(CertMatcher{Field: "serial", Matcher: ExactMatch("1")}))
+		assert.Error(t, err)
+		_, err = TLSConfig(AllowedIdentities(SubjectCN(StringMatcher{Type: MatchRegex, Value: "web"})))
+		assert.Error(t, err)
+	})
+	t.Run("check", func(t *testing.T) {
+		// MatchCert composes with the other checks
+		policy := Any(root.matchPin(t), MatchCert(DNSSAN(ExactMatch("web.example.org"))))
+		v := TLSVerifyPeerCertificate(SkipTLSVerify(), Checks(policy))
+		assert.NoError(t, v.Option()(rawChain(leaf), nil))
+	})
+}
@@ -0,0 +1,106 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/pkg/errors"
+)
+
+// alerts returns the alerts of the probe of the target i, the mu is held.
+// The report is nil if the probe did not get the chain.
+func (m *Monitor) alerts(i int, prev, s EndpointState, report *verify.Report) []Alert {
+	if m.cfg.WebhookURL == "" {
+		return nil
+	}
+	newAlert := func(kind, msg string) Alert {
+		return Alert{Kind: kind, Name: s.Name, Addr: s.Addr, Time: s.LastCheck, Message: msg}
+	}
+
+	var alerts []Alert
+	if !s.Healthy && (prev.Healthy || prev.Probes == 0) {
+		alerts = append(alerts, newAlert(AlertError, s.LastError))
+	}
+	if report == nil {
+		return alerts
+	}
+
+	// the keys of the previous leaves are not needed, the alerts are posted once per the leaf
+	for key := range m.fired[i] {
+		if !strings.HasPrefix(key, s.LeafSHA1+"/") {
+			delete(m.fired[i], key)
+		}
+	}
+
+	// the pin alert is posted only for the mismatch reported by the pin check
+	pinKey := s.LeafSHA1 + "/pin"
+	if s.Pinned && pinMismatch(report.Decision) && !m.fired[i][pinKey] {
+		m.fired[i][pinKey] = true
+		alerts = append(alerts, newAlert(AlertPin, fmt.Sprintf("the leaf %s (sha1 %s) does not match the pin", s.LeafSubject, s.LeafSHA1)))
+	}
+
+	// the alert of the smallest crossed threshold, the larger ones are not posted for the leaf
+	thresholds := append([]time.Duration(nil), m.cfg.Thresholds...)
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+	left := s.ChainNotAfter.Sub(s.LastCheck)
+	var crossed []time.Duration
+	for _, threshold := range thresholds {
+		key := fmt.Sprintf("%s/%s", s.LeafSHA1, threshold)
+		if left <= threshold && !m.fired[i][key] {
+			m.fired[i][key] = true
+			crossed = append(crossed, threshold)
+		}
+	}
+	if len(crossed) > 0 {
+		threshold := crossed[len(crossed)-1]
+		what := "the leaf " + s.LeafSubject
+		if s.ChainNotAfter.Before(s.LeafNotAfter) {
+			what = "a certificate in the chain of the leaf " + s.LeafSubject
+		}
+		a := newAlert(AlertExpiry, fmt.Sprintf("%s expires in %s (%s)",
+			what, left.Round(time.Minute), s.ChainNotAfter.UTC().Format(time.RFC3339)))
+		a.Threshold = threshold
+		a.NotAfter = s.ChainNotAfter
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+// pinMismatch reports whether the pin check failed, the pin compliance is unknown if it did not run.
+func pinMismatch(d verify.Decision) bool {
+	for _, err := range append([]error{d.Err}, d.Violations...) {
+		if errors.Is(err, verify.ErrNotMatchedFingerprint) || errors.Is(err, verify.ErrNotMatchedPin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Monitor) postAlert(a Alert) {
+	if err := m.post(a); err != nil {
+		m.mu.Lock()
+		m.alertErrors++
+		m.mu.Unlock()
+	}
+}
+
+func (m *Monitor) post(a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "failed marshal alert")
+	}
+	res, err := m.cfg.Client.Post(m.cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed post alert")
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return errors.Errorf("webhook responded %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handler returns the handler of the endpoints:
+//
+//	/state   - the states of the targets as JSON
+//	/metrics - the Prometheus metrics
+//	/healthz - 200 while the process is alive
+//	/readyz  - 200 after all targets are probed at least once, 503 before
+func (m *Monitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(m.State())
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WriteMetrics(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !m.Ready() {
+			http.Error(w, "not all targets are probed", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format.
+func (m *Monitor) WriteMetrics(w io.Writer) {
+	states := m.State()
+	m.mu.Lock()
+	alertErrors := m.alertErrors
+	m.mu.Unlock()
+
+	gauge := func(name, help string, value func(s EndpointState) (float64, bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, s := range states {
+			if v, ok := value(s); ok {
+				fmt.Fprintf(w, "%s{%s} %s\n", name, labels(s), strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+	counter := func(name, help string, value func(s EndpointState) int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, s := range states {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, labels(s), value(s))
+		}
+	}
+	probed := func(s EndpointState) bool { return !s.LastCheck.IsZero() }
+	connected := func(s EndpointState) bool { return !s.LeafNotAfter.IsZero() }
+
+	gauge("tls_monitor_up", "Whether the last probe connected and passed the policy.", func(s EndpointState) (float64, bool) {
+		return boolValue(s.Healthy), probed(s)
+	})
+	gauge("tls_monitor_last_check_timestamp_seconds", "Time of the last probe.", func(s EndpointState) (float64, bool) {
+		return float64(s.LastCheck.Unix()), probed(s)
+	})
+	gauge("tls_monitor_leaf_not_after_timestamp_seconds", "Expiry of the leaf cert.", func(s EndpointState) (float64, bool) {
+		return float64(s.LeafNotAfter.Unix()), connected(s)
+	})
+	gauge("tls_monitor_chain_not_after_timestamp_seconds", "Earliest expiry of the certs in the chain.", func(s EndpointState) (float64, bool) {
+		return float64(s.ChainNotAfter.Unix()), connected(s)
+	})
+	gauge("tls_monitor_pin_compliant", "Whether the leaf cert matches the pin.", func(s EndpointState) (float64, bool) {
+		return boolValue(s.PinCompliant), s.Pinned && connected(s)
+	})
+	counter("tls_monitor_probes_total", "Number of the probes.", func(s EndpointState) int { return s.Probes })
+	counter("tls_monitor_probe_errors_total", "Number of the failed probes.", func(s EndpointState) int { return s.Errors })
+	fmt.Fprintf(w, "# HELP tls_monitor_alert_errors_total Number of the alerts failed to post.\n# TYPE tls_monitor_alert_errors_total counter\ntls_monitor_alert_errors_total %d\n", alertErrors)
+}
+
+func labels(s EndpointState) string {
+	return fmt.Sprintf(`name="%s",addr="%s"`, escapeLabel(s.Name), escapeLabel(s.Addr))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,318 @@
+// Package monitor probes the TLS endpoints on an interval and tracks
+// the expiry of their certs, the pin compliance and the errors.
+// The state is exposed as JSON and Prometheus metrics (see Monitor.Handler)
+// and the alerts are posted to the webhook.
+package monitor
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/pkg/errors"
+)
+
+// Target is the probed endpoint.
+type Target struct {
+	// Name identifies the target in the state and the metrics, Addr by default.
+	Name string `json:"name,omitempty"`
+	// Addr is the "host:port" of the endpoint.
+	Addr string `json:"addr"`
+	// ServerName is sent and checked, the host of Addr by default.
+	ServerName string `json:"server_name,omitempty"`
+	// PinSHA1 is the expected SHA-1 fingerprint of the leaf cert.
+	PinSHA1 string `json:"pin_sha1,omitempty"`
+	// PinSHA256 is the expected SHA-256 of the leaf public key (RFC 7469 pin, e.g. "sha256/<base64>").
+	PinSHA256 string `json:"pin_sha256,omitempty"`
+	// SkipChain skips the verification of the chain and the hostname (for the pinned self-signed certs).
+	SkipChain bool `json:"skip_chain,omitempty"`
+}
+
+// LoadTargets reads the JSON list of the targets.
+func LoadTargets(r io.Reader) ([]Target, error) {
+	var targets []Target
+	if err := json.NewDecoder(r).Decode(&targets); err != nil {
+		return nil, errors.Wrap(err, "failed decode targets")
+	}
+	return targets, nil
+}
+
+// Config is the config of the Monitor. The zero values are replaced by the defaults.
+type Config struct {
+	// Interval between the probes of the target. Default 5m.
+	Interval time.Duration
+	// Jitter is the max random delay added to the interval (and before the first probe). Default Interval/10.
+	Jitter time.Duration
+	// Timeout of the probe. Default 10s.
+	Timeout time.Duration
+	// RootCAs are the trusted roots, the system roots if nil.
+	RootCAs *x509.CertPool
+
+	// Thresholds are the times before the earliest expiry in the chain (see ChainNotAfter)
+	// to alert at, e.g. 720h, 168h, 24h. The alert is posted once per the threshold and the leaf.
+	Thresholds []time.Duration
+	// WebhookURL is where the alerts are posted as JSON, the alerts are not posted if empty.
+	WebhookURL string
+	Client     *http.Client
+}
+
+// EndpointState is the state of the target after the last probe.
+type EndpointState struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+
+	// LastCheck is the time of the last probe, it is zero before the first probe.
+	LastCheck   time.Time `json:"last_check"`
+	LastSuccess time.Time `json:"last_success"`
+	// Healthy reports whether the last probe connected and passed the policy.
+	Healthy bool `json:"healthy"`
+	// LastError is the error of the last probe, it is empty if the probe is healthy.
+	LastError string `json:"last_error,omitempty"`
+
+	// The leaf fields are of the chain of the last probe, they are empty if the probe did not get the chain.
+	LeafSubject string `json:"leaf_subject,omitempty"`
+	LeafSHA1    string `json:"leaf_sha1,omitempty"`
+	// LeafNotAfter is the expiry of the leaf, ChainNotAfter is the earliest expiry in the chain.
+	LeafNotAfter  time.Time `json:"leaf_not_after"`
+	ChainNotAfter time.Time `json:"chain_not_after"`
+	Pinned        bool      `json:"pinned"`
+	// PinCompliant reports whether the leaf of the last probe matched the pin, it is false if the probe failed.
+	PinCompliant bool `json:"pin_compliant"`
+
+	Probes int `json:"probes"`
+	Errors int `json:"errors"`
+}
+
+// Alert is the JSON body posted to the webhook.
+type Alert struct {
+	// Kind is one of AlertExpiry, AlertPin or AlertError.
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name"`
+	Addr    string    `json:"addr"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	// Threshold and NotAfter are set for the expiry alert.
+	Threshold time.Duration `json:"threshold,omitempty"`
+	NotAfter  time.Time     `json:"not_after,omitempty"`
+}
+
+// The kinds of the alerts.
+const (
+	// AlertExpiry is posted when a cert of the chain expires within the threshold.
+	AlertExpiry = "expiry"
+	// AlertPin is posted when the leaf stops matching the pin.
+	AlertPin = "pin"
+	// AlertError is posted when the probe starts failing.
+	AlertError = "error"
+)
+
+// Monitor probes the targets.
+type Monitor struct {
+	cfg     Config
+	targets []Target
+
+	mu     sync.Mutex
+	states []EndpointState
+	// fired are the keys of the posted expiry and pin alerts of the current leaf per the target
+	fired       []map[string]bool
+	alertErrors int
+}
+
+// New returns the Monitor of the targets.
+func New(cfg Config, targets []Target) (*Monitor, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	if cfg.Jitter <= 0 {
+		cfg.Jitter = cfg.Interval / 10
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	m := &Monitor{
+		cfg:     cfg,
+		targets: make([]Target, len(targets)),
+		states:  make([]EndpointState, len(targets)),
+		fired:   make([]map[string]bool, len(targets)),
+	}
+	names := make(map[string]bool, len(targets))
+	for i, t := range targets {
+		if t.Addr == "" {
+			return nil, errors.Errorf("target %d: empty addr", i)
+		}
+		if t.Name == "" {
+			t.Name = t.Addr
+		}
+		if _, err := verify.NewTLSVerifyPeerCertificate(pinOptions(t)...); err != nil {
+			return nil, errors.Wrapf(err, "target %d", i)
+		}
+		if names[t.Name] {
+			return nil, errors.Errorf("target %d: duplicate name %q", i, t.Name)
+		}
+		names[t.Name] = true
+		m.targets[i] = t
+		m.states[i] = EndpointState{Name: t.Name, Addr: t.Addr, Pinned: t.PinSHA1 != "" || t.PinSHA256 != ""}
+		m.fired[i] = make(map[string]bool)
+	}
+	return m, nil
+}
+
+// Run probes each target on the interval with the jitter until the ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range m.targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			timer := time.NewTimer(m.jitter())
+			defer timer.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+				m.probe(ctx, i)
+				timer.Reset(m.cfg.Interval + m.jitter())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (m *Monitor) jitter() time.Duration {
+	return time.Duration(rand.Int63n(int64(m.cfg.Jitter) + 1))
+}
+
+// ProbeAll probes all targets once concurrently.
+func (m *Monitor) ProbeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range m.targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.probe(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// State returns the states of the targets in the order of the targets.
+func (m *Monitor) State() []EndpointState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make([]EndpointState, len(m.states))
+	copy(states, m.states)
+	return states
+}
+
+// Ready reports whether all targets are probed at least once.
+func (m *Monitor) Ready() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.states {
+		if s.LastCheck.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Monitor) probe(ctx context.Context, i int) {
+	t := m.targets[i]
+	probeCtx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	report, err := m.inspect(probeCtx, t)
+	cancel()
+	if ctx.Err() != nil {
+		// the monitor is stopped
+		return
+	}
+
+	m.mu.Lock()
+	prev := m.states[i]
+	s := prev
+	s.LastCheck = time.Now()
+	s.Probes++
+	s.LastError = ""
+	// the compliance and the chain of the failed probe are unknown
+	s.PinCompliant = false
+	s.LeafSubject, s.LeafSHA1 = "", ""
+	s.LeafNotAfter, s.ChainNotAfter = time.Time{}, time.Time{}
+	if err != nil {
+		s.LastError = err.Error()
+	} else {
+		s.LastError = strings.Join(report.Decision.Failures, " ")
+
+		leaf := report.Certificates[0]
+		s.LeafSubject = leaf.Subject
+		s.LeafSHA1 = leaf.Fingerprints.SHA1
+		s.LeafNotAfter = leaf.NotAfter
+		s.ChainNotAfter = leaf.NotAfter
+		for _, cert := range report.Certificates[1:] {
+			if cert.NotAfter.Before(s.ChainNotAfter) {
+				s.ChainNotAfter = cert.NotAfter
+			}
+		}
+		s.PinCompliant = s.Pinned && pinsPassed(t, report.Decision.Checks)
+	}
+	s.Healthy = s.LastError == ""
+	if s.Healthy {
+		s.LastSuccess = s.LastCheck
+	} else {
+		s.Errors++
+	}
+	m.states[i] = s
+	// the report is nil if the probe failed
+	alerts := m.alerts(i, prev, s, report)
+	m.mu.Unlock()
+
+	for _, a := range alerts {
+		m.postAlert(a)
+	}
+}
+
+// inspect connects to the target in the report-only mode, so the chain is reported for the failed policy.
+func (m *Monitor) inspect(ctx context.Context, t Target) (*verify.Report, error) {
+	opts := append([]verify.Option{
+		verify.ReportOnly(),
+		verify.DNSName(t.ServerName),
+	}, pinOptions(t)...)
+	if t.SkipChain {
+		opts = append(opts, verify.SkipTLSVerify())
+	} else {
+		opts = append(opts, verify.RootCAs(m.cfg.RootCAs))
+	}
+	return verify.Inspect(ctx, t.Addr, opts...)
+}
+
+// pinOptions returns the options of the pins of the target.
+func pinOptions(t Target) []verify.Option {
+	var opts []verify.Option
+	if t.PinSHA1 != "" {
+		opts = append(opts, verify.FingerprintSHA1(t.PinSHA1))
+	}
+	if t.PinSHA256 != "" {
+		opts = append(opts, verify.PinSHA256(t.PinSHA256))
+	}
+	return opts
+}
+
+// pinsPassed reports whether the checks of all pins of the target passed.
+func pinsPassed(t Target, checks []string) bool {
+	passed := make(map[string]bool, len(checks))
+	for _, check := range checks {
+		passed[check] = true
+	}
+	return (t.PinSHA1 == "" || passed[verify.CheckFingerprint]) && (t.PinSHA256 == "" || passed[verify.CheckPin])
+}
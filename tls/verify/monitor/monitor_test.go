@@ -0,0 +1,398 @@
+package monitor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gebv/go-lib/tls/minica"
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveTLS accepts the TLS connections with the self-signed cert for 127.0.0.1 valid until notAfter.
+func serveTLS(t *testing.T, notAfter time.Time) (string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return lis.Addr().String(), cert
+}
+
+func sha1Hex(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// webhook collects the posted alerts.
+type webhook struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var a Alert
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	h.alerts = append(h.alerts, a)
+	h.mu.Unlock()
+}
+
+func (h *webhook) kinds() map[string][]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kinds := make(map[string][]string)
+	for _, a := range h.alerts {
+		kinds[a.Name] = append(kinds[a.Name], a.Kind)
+	}
+	return kinds
+}
+
+func TestMonitor(t *testing.T) {
+	okAddr, okCert := serveTLS(t, time.Now().Add(90*24*time.Hour))
+	soonAddr, soonCert := serveTLS(t, time.Now().Add(2*time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(okCert)
+	roots.AddCert(soonCert)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	hook := &webhook{}
+	hookSrv := httptest.NewServer(hook)
+	defer hookSrv.Close()
+
+	m, err := New(Config{
+		RootCAs:    roots,
+		Thresholds: []time.Duration{24 * time.Hour, 30 * 24 * time.Hour},
+		WebhookURL: hookSrv.URL,
+	}, []Target{
+		{Name: "ok", Addr: okAddr, PinSHA1: sha1Hex(okCert)},
+		{Name: "soon", Addr: soonAddr},
+		{Name: "pin", Addr: okAddr, PinSHA1: "81f344a7686a80b4c5293e8fdc0b0160c82c06a8", SkipChain: true},
+		{Name: "untrusted", Addr: okAddr, ServerName: "example.org"},
+		{Addr: closedAddr},
+	})
+	require.NoError(t, err)
+	assert.False(t, m.Ready())
+
+	m.ProbeAll(context.Background())
+	assert.True(t, m.Ready())
+
+	states := m.State()
+	require.Len(t, states, 5)
+
+	ok := states[0]
+	assert.True(t, ok.Healthy, ok.LastError)
+	assert.Equal(t, ok.LastCheck, ok.LastSuccess)
+	assert.Equal(t, okCert.NotAfter, ok.LeafNotAfter)
+	assert.Equal(t, okCert.NotAfter, ok.ChainNotAfter)
+	assert.Equal(t, sha1Hex(okCert), ok.LeafSHA1)
+	assert.True(t, ok.Pinned)
+	assert.True(t, ok.PinCompliant)
+	assert.Equal(t, 1, ok.Probes)
+	assert.Equal(t, 0, ok.Errors)
+
+	assert.True(t, states[1].Healthy, states[1].LastError)
+	assert.Equal(t, soonCert.NotAfter, states[1].LeafNotAfter)
+
+	pin := states[2]
+	assert.False(t, pin.Healthy)
+	assert.False(t, pin.PinCompliant)
+	assert.Contains(t, pin.LastError, "does not match the pinned fingerprint")
+
+	assert.False(t, states[3].Healthy)
+	assert.Contains(t, states[3].LastError, "not valid for example.org")
+
+	assert.Equal(t, closedAddr, states[4].Name)
+	assert.False(t, states[4].Healthy)
+	assert.True(t, states[4].LeafNotAfter.IsZero())
+	assert.Equal(t, 1, states[4].Errors)
+
+	assert.Equal(t, map[string][]string{
+		"soon":      {AlertExpiry},
+		"pin":       {AlertError, AlertPin},
+		"untrusted": {AlertError},
+		closedAddr:  {AlertError},
+	}, hook.kinds())
+	for _, a := range hook.alerts {
+		if a.Kind == AlertExpiry {
+			assert.Equal(t, 24*time.Hour, a.Threshold)
+			assert.Equal(t, soonCert.NotAfter.Unix(), a.NotAfter.Unix())
+		}
+	}
+
+	// the alerts are not repeated
+	m.ProbeAll(context.Background())
+	assert.Len(t, hook.kinds()["soon"], 1)
+	assert.Len(t, hook.kinds()["pin"], 2)
+	assert.Equal(t, 2, m.State()[4].Errors)
+}
+
+func TestMonitor_pinAlerts(t *testing.T) {
+	pinnedAddr, pinnedCert := serveTLS(t, time.Now().Add(90*24*time.Hour))
+	otherAddr, otherCert := serveTLS(t, time.Now().Add(90*24*time.Hour))
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	hook := &webhook{}
+	hookSrv := httptest.NewServer(hook)
+	defer hookSrv.Close()
+
+	m, err := New(Config{
+		Thresholds: []time.Duration{365 * 24 * time.Hour},
+		WebhookURL: hookSrv.URL,
+	}, []Target{{Name: "web", Addr: pinnedAddr, PinSHA1: sha1Hex(pinnedCert), SkipChain: true}})
+	require.NoError(t, err)
+	probe := func(addr string) EndpointState {
+		m.targets[0].Addr = addr
+		m.ProbeAll(context.Background())
+		return m.State()[0]
+	}
+
+	s := probe(pinnedAddr)
+	assert.True(t, s.PinCompliant)
+
+	// the compliance and the chain are reset by the failed probe
+	s = probe(closedAddr)
+	assert.False(t, s.Healthy)
+	assert.False(t, s.PinCompliant)
+	assert.Empty(t, s.LeafSubject)
+	assert.Empty(t, s.LeafSHA1)
+	assert.True(t, s.LeafNotAfter.IsZero())
+	assert.True(t, s.ChainNotAfter.IsZero())
+	var metrics strings.Builder
+	m.WriteMetrics(&metrics)
+	assert.NotContains(t, metrics.String(), "tls_monitor_leaf_not_after_timestamp_seconds{")
+	assert.NotContains(t, metrics.String(), "tls_monitor_chain_not_after_timestamp_seconds{")
+
+	s = probe(otherAddr)
+	assert.False(t, s.PinCompliant)
+	s = probe(otherAddr)
+	assert.Equal(t, []string{AlertExpiry, AlertError, AlertPin, AlertExpiry}, hook.kinds()["web"], "the alerts are posted once per the leaf")
+
+	// the keys of the previous leaf are dropped
+	m.mu.Lock()
+	for key := range m.fired[0] {
+		assert.True(t, strings.HasPrefix(key, sha1Hex(otherCert)+"/"), key)
+	}
+	m.mu.Unlock()
+}
+
+func TestMonitor_pinSHA256(t *testing.T) {
+	addr, cert := serveTLS(t, time.Now().Add(90*24*time.Hour))
+	_, otherCert := serveTLS(t, time.Now().Add(90*24*time.Hour))
+
+	hook := &webhook{}
+	hookSrv := httptest.NewServer(hook)
+	defer hookSrv.Close()
+
+	m, err := New(Config{WebhookURL: hookSrv.URL}, []Target{
+		{Name: "ok", Addr: addr, PinSHA256: "sha256/" + verify.CertFingerprints(cert).SPKISHA256, SkipChain: true},
+		{Name: "both", Addr: addr, PinSHA1: sha1Hex(cert), PinSHA256: "sha256/" + verify.CertFingerprints(otherCert).SPKISHA256, SkipChain: true},
+	})
+	require.NoError(t, err)
+	m.ProbeAll(context.Background())
+
+	states := m.State()
+	assert.True(t, states[0].Healthy, states[0].LastError)
+	assert.True(t, states[0].Pinned)
+	assert.True(t, states[0].PinCompliant)
+	assert.False(t, states[1].Healthy)
+	assert.False(t, states[1].PinCompliant, "the both pins should match")
+	assert.Equal(t, map[string][]string{"both": {AlertError, AlertPin}}, hook.kinds())
+
+	_, err = New(Config{}, []Target{{Addr: "a:1", PinSHA256: "sha256/abc"}})
+	assert.Error(t, err)
+}
+
+func TestMonitor_chainExpiry(t *testing.T) {
+	root, err := minica.NewRoot(minica.Request{Subject: pkix.Name{CommonName: "Root"}})
+	require.NoError(t, err)
+	intermediate, err := root.NewIntermediate(minica.Request{Subject: pkix.Name{CommonName: "Intermediate"}, NotAfter: time.Now().Add(2 * time.Hour)})
+	require.NoError(t, err)
+	leaf, err := intermediate.IssueServer(minica.Request{Hosts: []string{"127.0.0.1"}, Validity: 90 * 24 * time.Hour})
+	require.NoError(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{leaf.TLSCertificate(intermediate)}})
+	require.NoError(t, err)
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	hook := &webhook{}
+	hookSrv := httptest.NewServer(hook)
+	defer hookSrv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+
+	m, err := New(Config{RootCAs: roots, Thresholds: []time.Duration{24 * time.Hour}, WebhookURL: hookSrv.URL}, []Target{{Name: "web", Addr: lis.Addr().String()}})
+	require.NoError(t, err)
+	m.ProbeAll(context.Background())
+
+	s := m.State()[0]
+	assert.True(t, s.Healthy, s.LastError)
+	assert.Equal(t, leaf.Cert.NotAfter, s.LeafNotAfter)
+	assert.Equal(t, intermediate.Cert.NotAfter, s.ChainNotAfter)
+	require.Len(t, hook.alerts, 1)
+	a := hook.alerts[0]
+	assert.Equal(t, AlertExpiry, a.Kind)
+	assert.Equal(t, 24*time.Hour, a.Threshold)
+	assert.Equal(t, intermediate.Cert.NotAfter.Unix(), a.NotAfter.Unix())
+	assert.Contains(t, a.Message, "a certificate in the chain of the leaf")
+}
+
+func TestMonitor_Handler(t *testing.T) {
+	addr, cert := serveTLS(t, time.Now().Add(time.Hour))
+	m, err := New(Config{}, []Target{{Name: `a"b`, Addr: addr, PinSHA1: sha1Hex(cert), SkipChain: true}})
+	require.NoError(t, err)
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		res, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var body strings.Builder
+		_, err = io.Copy(&body, res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, body.String()
+	}
+
+	code, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	m.ProbeAll(context.Background())
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body := get("/state")
+	assert.Equal(t, http.StatusOK, code)
+	var states []EndpointState
+	require.NoError(t, json.Unmarshal([]byte(body), &states))
+	require.Len(t, states, 1)
+	assert.True(t, states[0].Healthy)
+
+	code, body = get("/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	labels := `{name="a\"b",addr="` + addr + `"}`
+	for _, want := range []string{
+		"# TYPE tls_monitor_up gauge\ntls_monitor_up" + labels + " 1\n",
+		"tls_monitor_leaf_not_after_timestamp_seconds" + labels + " " + strconv.FormatInt(cert.NotAfter.Unix(), 10) + "\n",
+		"tls_monitor_pin_compliant" + labels + " 1\n",
+		"# TYPE tls_monitor_probes_total counter\ntls_monitor_probes_total" + labels + " 1\n",
+		"tls_monitor_probe_errors_total" + labels + " 0\n",
+		"tls_monitor_alert_errors_total 0\n",
+	} {
+		assert.Contains(t, body, want)
+	}
+}
+
+func TestMonitor_Run(t *testing.T) {
+	addr, _ := serveTLS(t, time.Now().Add(time.Hour))
+	m, err := New(Config{Interval: 10 * time.Millisecond, Jitter: time.Millisecond}, []Target{{Addr: addr, SkipChain: true}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return m.State()[0].Probes >= 3 }, 5*time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+	assert.True(t, m.State()[0].Healthy, m.State()[0].LastError)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{}, []Target{{Name: "a"}})
+	assert.EqualError(t, err, "target 0: empty addr")
+	_, err = New(Config{}, []Target{{Addr: "a:1"}, {Addr: "a:1"}})
+	assert.EqualError(t, err, `target 1: duplicate name "a:1"`)
+	_, err = New(Config{}, []Target{{Addr: "a:1", PinSHA1: "abc"}})
+	assert.EqualError(t, err, `target 0: invalid fingerprint "abc": odd number of hex digits`)
+}
+
+func TestLoadTargets(t *testing.T) {
+	targets, err := LoadTargets(strings.NewReader(`[
+		{"addr": "example.com:443", "pin_sha1": "ab:cd"},
+		{"name": "self", "addr": "localhost:10010", "server_name": "localhost", "skip_chain": true}
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, []Target{
+		{Addr: "example.com:443", PinSHA1: "ab:cd"},
+		{Name: "self", Addr: "localhost:10010", ServerName: "localhost", SkipChain: true},
+	}, targets)
+
+	_, err = LoadTargets(strings.NewReader(`{`))
+	assert.Error(t, err)
+}
@@ -1,27 +1,163 @@
 package verify
 
-type tlsVerifyPeerCertificateOption func(opts *tlsVerifyPeerCertificateOptions)
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+)
 
-type tlsVerifyPeerCertificateOptions struct {
-	SkipTLSVerify   bool
-	DNSName         string
+// Option sets the options of the verifier.
+type Option func(opts *Options)
+
+// Options are the options of the verifier, they are set by the Option functions.
+type Options struct {
+	SkipTLSVerify bool
+	DNSName       string
+	// SHA1Fingerprint is the SHA-1 of the leaf cert in the form accepted by ParseFingerprint.
 	SHA1Fingerprint string
+	// PinsSHA256 are the SHA-256 of the leaf public key (RFC 7469) in the forms accepted by ParseFingerprint.
+	PinsSHA256 []string
+	RootCAs    *x509.CertPool
+
+	TLSAResolver TLSAResolver
+	TLSAHost     string
+	TLSAPort     int
+
+	// SPIFFEBundles are the roots of the SPIFFE trust domains, see SPIFFEBundle.
+	SPIFFEBundles map[string]*x509.CertPool
+	// SPIFFEIDs are the matchers of the allowed SPIFFE IDs, see AllowedSPIFFEIDs.
+	SPIFFEIDs []StringMatcher
+
+	// Identities are the groups of the matchers, the leaf should match one matcher of each group.
+	Identities [][]CertMatcher
+	// Checks are run after the built-in checks (see the Checks option).
+	Checks []Check
+
+	ReportOnly   bool
+	Observers    []func(Event)
+	CaptureChain bool
+
+	// clientCerts is set for the verification of the client certs by the server
+	clientCerts bool
 }
 
-func SkipTLSVerify() tlsVerifyPeerCertificateOption {
-	return func(opts *tlsVerifyPeerCertificateOptions) {
+func SkipTLSVerify() Option {
+	return func(opts *Options) {
 		opts.SkipTLSVerify = true
 	}
 }
 
-func FingerprintSHA1(sha1hex string) tlsVerifyPeerCertificateOption {
-	return func(opts *tlsVerifyPeerCertificateOptions) {
+// FingerprintSHA1 sets the SHA-1 fingerprint of the leaf cert in the forms accepted by ParseFingerprint.
+// The malformed fingerprint fails the verifier construction (and each verification).
+func FingerprintSHA1(sha1hex string) Option {
+	return func(opts *Options) {
 		opts.SHA1Fingerprint = sha1hex
 	}
 }
 
-func DNSName(dnsName string) tlsVerifyPeerCertificateOption {
-	return func(opts *tlsVerifyPeerCertificateOptions) {
+// PinSHA256 adds the pins of the leaf public key, the leaf should match one of them.
+// The pin is the SHA-256 of the SubjectPublicKeyInfo, usually "sha256/<base64>" (see ParseFingerprint).
+func PinSHA256(pins ...string) Option {
+	return func(opts *Options) {
+		opts.PinsSHA256 = append(opts.PinsSHA256, pins...)
+	}
+}
+
+func DNSName(dnsName string) Option {
+	return func(opts *Options) {
 		opts.DNSName = dnsName
 	}
 }
+
+// RootCAs sets the roots for the chain verification. The system roots are used by default.
+func RootCAs(pool *x509.CertPool) Option {
+	return func(opts *Options) {
+		opts.RootCAs = pool
+	}
+}
+
+// DANE verifies the server cert by the TLSA records of the service
+// (_port._tcp.host) instead of the chain verification.
+// If host is empty the DNSName is used.
+func DANE(resolver TLSAResolver, host string, port int) Option {
+	return func(opts *Options) {
+		opts.TLSAResolver = resolver
+		opts.TLSAHost = host
+		opts.TLSAPort = port
+	}
+}
+
+// ReportOnly enables the report-only mode: the failed checks do not fail the handshake
+// but they are kept in the Result.Violations and passed to the observers.
+func ReportOnly() Option {
+	return func(opts *Options) {
+		opts.ReportOnly = true
+	}
+}
+
+// Observer adds the observer of each verification.
+// The observers are called synchronously in the handshake.
+func Observer(observe func(Event)) Option {
+	return func(opts *Options) {
+		opts.Observers = append(opts.Observers, observe)
+	}
+}
+
+// CaptureChain keeps the chain presented in the failed handshake. The chain is
+// available by CapturedChainFromError and in the Event.Chain.
+func CaptureChain() Option {
+	return func(opts *Options) {
+		opts.CaptureChain = true
+	}
+}
+
+// clone returns the copy of the options that shares no slices and maps with o,
+// so the options applied over the copy do not change o.
+func (o *Options) clone() *Options {
+	c := *o
+	c.PinsSHA256 = append([]string(nil), o.PinsSHA256...)
+	c.SPIFFEIDs = append([]StringMatcher(nil), o.SPIFFEIDs...)
+	c.Checks = append([]Check(nil), o.Checks...)
+	c.Observers = append(([]func(Event))(nil), o.Observers...)
+	if o.Identities != nil {
+		c.Identities = make([][]CertMatcher, len(o.Identities))
+		for i, matchers := range o.Identities {
+			c.Identities[i] = append([]CertMatcher(nil), matchers...)
+		}
+	}
+	if o.SPIFFEBundles != nil {
+		c.SPIFFEBundles = make(map[string]*x509.CertPool, len(o.SPIFFEBundles))
+		for td, roots := range o.SPIFFEBundles {
+			c.SPIFFEBundles[td] = roots
+		}
+	}
+	return &c
+}
+
+// knownPins returns the expected pins in the form "algorithm/value".
+func (o *Options) knownPins() []string {
+	var pins []string
+	if o.SHA1Fingerprint != "" {
+		if sum, err := parseFingerprintOf("sha1", o.SHA1Fingerprint); err == nil {
+			pins = append(pins, "sha1/"+hex.EncodeToString(sum))
+		}
+	}
+	pins256, _ := o.pinsSHA256()
+	for _, pin := range pins256 {
+		pins = append(pins, "sha256/"+base64.StdEncoding.EncodeToString(pin))
+	}
+	return pins
+}
+
+// pinsSHA256 returns the parsed PinsSHA256.
+func (o *Options) pinsSHA256() ([][]byte, error) {
+	pins := make([][]byte, 0, len(o.PinsSHA256))
+	for _, pin := range o.PinsSHA256 {
+		sum, err := parseFingerprintOf("sha256", pin)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, sum)
+	}
+	return pins, nil
+}
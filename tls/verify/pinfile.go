@@ -0,0 +1,178 @@
+package verify
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// PinFromFile returns the SPKI pin ("sha256/<base64>", see PinSHA256) of the public key
+// in the file: the cert, the CSR, the public or the private key in PEM or DER.
+// The keys are RSA, ECDSA or Ed25519 in PKIX, PKCS#1, PKCS#8 or SEC1.
+// For the PEM file with several blocks the first one with the public key is used.
+func PinFromFile(file string) (string, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrap(err, "failed read pin file")
+	}
+	pin, err := PinFromBytes(dat)
+	if err != nil {
+		return "", errors.Wrapf(err, "file %q", file)
+	}
+	return pin, nil
+}
+
+// PinFromBytes returns the SPKI pin of the PEM or DER data (see PinFromFile).
+func PinFromBytes(dat []byte) (string, error) {
+	spki, err := subjectPublicKeyInfo(dat)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(spki)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// FingerprintSHA1FromFile returns the SHA-1 fingerprint in hex (see FingerprintSHA1)
+// of the cert in the PEM or DER file, or the fingerprint stored in the file
+// in the forms accepted by ParseFingerprint (e.g. the output of "openssl x509 -fingerprint").
+func FingerprintSHA1FromFile(file string) (string, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrap(err, "failed read fingerprint file")
+	}
+
+	der := dat
+	if block, _ := pem.Decode(dat); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return "", errors.Errorf("file %q: unexpected PEM block %q", file, block.Type)
+		}
+		der = block.Bytes
+	}
+	if _, err := x509.ParseCertificate(der); err == nil {
+		sum := sha1.Sum(der)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	sum, err := parseFingerprintOf("sha1", string(dat))
+	if err != nil {
+		return "", errors.Wrapf(err, "file %q is neither certificate nor fingerprint", file)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// subjectPublicKeyInfo returns the DER SubjectPublicKeyInfo of the first PEM block with the public key or of the DER data.
+func subjectPublicKeyInfo(dat []byte) ([]byte, error) {
+	rest := dat
+	found := false
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		found = true
+		if x509.IsEncryptedPEMBlock(block) {
+			return nil, errors.Errorf("encrypted PEM block %q", block.Type)
+		}
+		parse, ok := pemParsers[block.Type]
+		if !ok {
+			continue
+		}
+		spki, err := parse(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed parse PEM block %q", block.Type)
+		}
+		return spki, nil
+	}
+	if found {
+		return nil, errors.New("no public key in PEM blocks")
+	}
+
+	for _, parse := range derParsers {
+		if spki, err := parse(dat); err == nil {
+			return spki, nil
+		}
+	}
+	return nil, errors.New("neither PEM nor DER of certificate, CSR or key")
+}
+
+type spkiParser func(der []byte) ([]byte, error)
+
+var pemParsers = map[string]spkiParser{
+	"CERTIFICATE":             certSPKI,
+	"CERTIFICATE REQUEST":     csrSPKI,
+	"NEW CERTIFICATE REQUEST": csrSPKI,
+	"PUBLIC KEY":              pkixSPKI,
+	"RSA PUBLIC KEY":          pkcs1PublicSPKI,
+	"PRIVATE KEY":             pkcs8SPKI,
+	"RSA PRIVATE KEY":         pkcs1PrivateSPKI,
+	"EC PRIVATE KEY":          sec1SPKI,
+}
+
+var derParsers = []spkiParser{certSPKI, csrSPKI, pkixSPKI, pkcs1PublicSPKI, pkcs8SPKI, pkcs1PrivateSPKI, sec1SPKI}
+
+func certSPKI(der []byte) ([]byte, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return cert.RawSubjectPublicKeyInfo, nil
+}
+
+func csrSPKI(der []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	return csr.RawSubjectPublicKeyInfo, nil
+}
+
+func pkixSPKI(der []byte) ([]byte, error) {
+	if _, err := x509.ParsePKIXPublicKey(der); err != nil {
+		return nil, err
+	}
+	return der, nil
+}
+
+func pkcs1PublicSPKI(der []byte) ([]byte, error) {
+	key, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKIXPublicKey(key)
+}
+
+func pkcs8SPKI(der []byte) ([]byte, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key %T", key)
+	}
+	return x509.MarshalPKIXPublicKey(signer.Public())
+}
+
+func pkcs1PrivateSPKI(der []byte) ([]byte, error) {
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKIXPublicKey(key.Public())
+}
+
+func sec1SPKI(der []byte) ([]byte, error) {
+	key, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKIXPublicKey(key.Public())
+}
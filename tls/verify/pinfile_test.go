@@ -0,0 +1,132 @@
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testdataSSL = "../../test/testdata/verify/ssl/"
+
+func TestPinFromFile_testdata(t *testing.T) {
+	// the cert, the CSR and the key deployed to the server have the same pin
+	for _, name := range []string{"selfsigned-localhost-ok", "selfsigned-localhost-expired"} {
+		certPin, err := PinFromFile(testdataSSL + name + ".crt")
+		require.NoError(t, err)
+		csrPin, err := PinFromFile(testdataSSL + name + ".csr")
+		require.NoError(t, err)
+		keyPin, err := PinFromFile(testdataSSL + name + ".key")
+		require.NoError(t, err)
+		assert.Equal(t, certPin, csrPin, name)
+		assert.Equal(t, certPin, keyPin, name)
+	}
+}
+
+func TestPinFromBytes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pinOf := func(pub interface{}) string {
+		spki, err := x509.MarshalPKIXPublicKey(pub)
+		require.NoError(t, err)
+		sum := sha256.Sum256(spki)
+		return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	pkcs8 := func(key interface{}) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		return der
+	}
+	pkix := func(pub interface{}) []byte {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		require.NoError(t, err)
+		return der
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"localhost"}}, ecKey)
+	require.NoError(t, err)
+	leaf := newTestLeaf(t, nil, "localhost")
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+		want  string
+	}{
+		{"certificate", &pem.Block{Type: "CERTIFICATE", Bytes: leaf.cert.Raw}, "sha256/" + CertFingerprints(leaf.cert).SPKISHA256},
+		{"csr", &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}, pinOf(ecKey.Public())},
+		{"pkixRSA", &pem.Block{Type: "PUBLIC KEY", Bytes: pkix(rsaKey.Public())}, pinOf(rsaKey.Public())},
+		{"pkixEd25519", &pem.Block{Type: "PUBLIC KEY", Bytes: pkix(edKey.Public())}, pinOf(edKey.Public())},
+		{"pkcs1Public", &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)}, pinOf(rsaKey.Public())},
+		{"pkcs1Private", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, pinOf(rsaKey.Public())},
+		{"pkcs8RSA", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(rsaKey)}, pinOf(rsaKey.Public())},
+		{"pkcs8ECDSA", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(ecKey)}, pinOf(ecKey.Public())},
+		{"pkcs8Ed25519", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(edKey)}, pinOf(edKey.Public())},
+		{"sec1", &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}, pinOf(ecKey.Public())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PinFromBytes(pem.EncodeToMemory(tt.block))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			got, err = PinFromBytes(tt.block.Bytes)
+			require.NoError(t, err, "DER")
+			assert.Equal(t, tt.want, got, "DER")
+		})
+	}
+
+	t.Run("skipsOtherBlocks", func(t *testing.T) {
+		dat := pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{6, 8, 42}})
+		dat = append(dat, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1})...)
+		got, err := PinFromBytes(dat)
+		require.NoError(t, err)
+		assert.Equal(t, pinOf(ecKey.Public()), got)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := PinFromBytes([]byte("garbage"))
+		assert.EqualError(t, err, "neither PEM nor DER of certificate, CSR or key")
+		_, err = PinFromBytes(pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{6, 8, 42}}))
+		assert.EqualError(t, err, "no public key in PEM blocks")
+		_, err = PinFromBytes(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1, 2}}))
+		assert.Error(t, err)
+	})
+}
+
+func TestFingerprintSHA1FromFile(t *testing.T) {
+	for _, name := range []string{"selfsigned-localhost-ok", "selfsigned-localhost-simple"} {
+		fromCert, err := FingerprintSHA1FromFile(testdataSSL + name + ".crt")
+		require.NoError(t, err)
+		fromSHA1, err := FingerprintSHA1FromFile(testdataSSL + name + ".crt.sha1")
+		require.NoError(t, err)
+		assert.Equal(t, fromCert, fromSHA1, name)
+	}
+
+	leaf := newTestLeaf(t, nil, "localhost")
+	der := filepath.Join(t.TempDir(), "leaf.der")
+	require.NoError(t, ioutil.WriteFile(der, leaf.cert.Raw, 0600))
+	got, err := FingerprintSHA1FromFile(der)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.sha1(), got)
+
+	_, err = FingerprintSHA1FromFile(testdataSSL + "selfsigned-localhost-ok.key")
+	assert.Error(t, err)
+	_, err = FingerprintSHA1FromFile(testdataSSL + "missing.crt")
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+// Package recording records the chains presented by the endpoints
+// into the files for the replay servers of the verifytest package.
+package recording
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Recording is the chain (and the OCSP staple) presented by the endpoint.
+// It is stored in the JSON file with the certs in PEM (see WriteFile and Load).
+type Recording struct {
+	Addr       string    `json:"addr"`
+	ServerName string    `json:"server_name"`
+	RecordedAt time.Time `json:"recorded_at"`
+	// Chain is the presented chain in PEM, leaf first.
+	Chain []string `json:"chain"`
+	// OCSPStaple is the stapled OCSP response, it is empty if not stapled.
+	OCSPStaple []byte `json:"ocsp_staple,omitempty"`
+}
+
+// Record connects to the address and records the presented chain and the OCSP staple.
+// The chain is not verified. If serverName is empty the host of addr is sent.
+func Record(ctx context.Context, addr, serverName string) (*Recording, error) {
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid address")
+		}
+		serverName = host
+	}
+	d := &tls.Dialer{Config: &tls.Config{
+		ServerName: serverName,
+		// the chain is recorded as presented, whatever it is
+		InsecureSkipVerify: true,
+	}}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed connect")
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+
+	r := &Recording{
+		Addr:       addr,
+		ServerName: serverName,
+		RecordedAt: time.Now().UTC(),
+		OCSPStaple: state.OCSPResponse,
+	}
+	for _, cert := range state.PeerCertificates {
+		r.Chain = append(r.Chain, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	}
+	return r, nil
+}
+
+// Load reads the recording from the file written by WriteFile.
+func Load(file string) (*Recording, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read recording")
+	}
+	r := &Recording{}
+	if err := json.Unmarshal(dat, r); err != nil {
+		return nil, errors.Wrapf(err, "failed parse recording %q", file)
+	}
+	if _, err := r.Certificates(); err != nil {
+		return nil, errors.Wrapf(err, "invalid recording %q", file)
+	}
+	return r, nil
+}
+
+// WriteFile writes the recording to the file.
+func (r *Recording) WriteFile(file string) error {
+	dat, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed marshal recording")
+	}
+	return errors.Wrap(ioutil.WriteFile(file, append(dat, '\n'), 0644), "failed write recording")
+}
+
+// RawChain returns the DER of the recorded chain as the verifiers of the peer certs get it.
+func (r *Recording) RawChain() ([][]byte, error) {
+	raw := make([][]byte, len(r.Chain))
+	for i, c := range r.Chain {
+		block, _ := pem.Decode([]byte(c))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, errors.Errorf("cert %d is not PEM of certificate", i)
+		}
+		raw[i] = block.Bytes
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty chain")
+	}
+	return raw, nil
+}
+
+// Certificates returns the recorded chain.
+func (r *Recording) Certificates() ([]*x509.Certificate, error) {
+	raw, err := r.RawChain()
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return nil, errors.Wrapf(err, "failed parse cert %d", i)
+		}
+	}
+	return certs, nil
+}
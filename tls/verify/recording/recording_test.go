@@ -0,0 +1,83 @@
+package recording
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gebv/go-lib/tls/minica"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveTLS accepts the TLS connections with the chain of the leaf for localhost issued by the root.
+func serveTLS(t *testing.T, staple []byte) (string, *minica.Cert) {
+	t.Helper()
+
+	root, err := minica.NewRoot(minica.Request{NotBefore: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	leaf, err := root.IssueServer(minica.Request{Hosts: []string{"localhost"}, NotBefore: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+	cert := leaf.TLSCertificate(root)
+	cert.OCSPStaple = staple
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return lis.Addr().String(), leaf
+}
+
+func TestRecord(t *testing.T) {
+	addr, leaf := serveTLS(t, []byte("staple"))
+
+	rec, err := Record(context.Background(), addr, "localhost")
+	require.NoError(t, err)
+	assert.Equal(t, addr, rec.Addr)
+	assert.Equal(t, "localhost", rec.ServerName)
+	assert.Equal(t, []byte("staple"), rec.OCSPStaple)
+	require.Len(t, rec.Chain, 2)
+
+	file := filepath.Join(t.TempDir(), "recorded.json")
+	require.NoError(t, rec.WriteFile(file))
+	loaded, err := Load(file)
+	require.NoError(t, err)
+	assert.Equal(t, rec.Chain, loaded.Chain)
+	certs, err := loaded.Certificates()
+	require.NoError(t, err)
+	assert.True(t, leaf.Cert.Equal(certs[0]))
+
+	t.Run("serverName", func(t *testing.T) {
+		rec, err := Record(context.Background(), addr, "")
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1", rec.ServerName)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := (&Recording{}).RawChain()
+		assert.EqualError(t, err, "empty chain")
+		_, err = (&Recording{Chain: []string{"not PEM"}}).Certificates()
+		assert.EqualError(t, err, "cert 0 is not PEM of certificate")
+
+		file := filepath.Join(t.TempDir(), "invalid.json")
+		require.NoError(t, ioutil.WriteFile(file, []byte(`{"chain": []}`), 0600))
+		_, err = Load(file)
+		assert.Error(t, err)
+
+		_, err = Record(context.Background(), "localhost", "")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,285 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ViolationReport is the JSON report of the failed verification,
+// the names of the fields follow the HPKP reports (RFC 7469 section 3).
+type ViolationReport struct {
+	Time     time.Time `json:"date-time"`
+	Hostname string    `json:"hostname"`
+	// Port is 0 if the dialed address is unknown (e.g. for TLSConfig).
+	Port int `json:"port"`
+	// ServedCertificateChain is the presented chain in PEM, leaf first.
+	ServedCertificateChain []string `json:"served-certificate-chain"`
+	// KnownPins are the expected SPKI pins in the HPKP form pin-sha256="<base64>".
+	KnownPins []string `json:"known-pins"`
+	// KnownFingerprints are the expected fingerprints of the leaf that are not the pins
+	// of the public key, e.g. "sha1/<hex>" of FingerprintSHA1.
+	KnownFingerprints []string `json:"known-fingerprints,omitempty"`
+	// Reasons are the errors of the failed checks.
+	Reasons []string `json:"failure-reasons"`
+	// Enforced is false in the report-only mode.
+	Enforced bool `json:"enforced"`
+}
+
+// ReportSenderConfig is the config of the ReportSender.
+// The zero values are replaced by the defaults.
+type ReportSenderConfig struct {
+	// URL is the collector of the reports.
+	URL    string
+	Client *http.Client
+	// QueueSize is the number of the queued reports, the reports over it are dropped. Default 100.
+	QueueSize int
+	// MaxRetries of the failed POST. Default 3.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, it is doubled for each next retry. Default 1s.
+	RetryBackoff time.Duration
+	// RateLimit is the number of the reports per second, the reports over it are dropped. Default 1.
+	RateLimit float64
+	// RateBurst is the number of the reports sent at once. Default 10.
+	RateBurst int
+}
+
+// ReportSender posts the violation reports to the collector asynchronously.
+// It never blocks the handshake: the reports over the queue size or the rate limit are dropped.
+type ReportSender struct {
+	cfg   ReportSenderConfig
+	queue chan *ViolationReport
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	sent    uint64
+	dropped uint64
+	failed  uint64
+}
+
+// NewReportSender returns the started sender.
+func NewReportSender(cfg ReportSenderConfig) *ReportSender {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.RateLimit <= 0 {
+		cfg.RateLimit = 1
+	}
+	if cfg.RateBurst <= 0 {
+		cfg.RateBurst = 10
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &ReportSender{
+		cfg:    cfg,
+		queue:  make(chan *ViolationReport, cfg.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		tokens: float64(cfg.RateBurst),
+		last:   time.Now(),
+	}
+	go s.run()
+	return s
+}
+
+// ReportTo posts the reports of the failed verifications by the sender.
+// The sender is owned by the caller: close it when the verifier is not used.
+// It is no-op if the sender is nil.
+func ReportTo(sender *ReportSender) Option {
+	if sender == nil {
+		return func(*Options) {}
+	}
+	return Observer(sender.Observe)
+}
+
+// Observe queues the report if the verification failed or has violations.
+// The failed verification is reported even without the result (e.g. the unparsable chain).
+// Use it as the Observer option.
+func (s *ReportSender) Observe(e Event) {
+	if e.Err == nil && (e.Result == nil || len(e.Result.Violations) == 0) {
+		return
+	}
+	s.Send(newViolationReport(e))
+}
+
+// Send queues the report. The report is dropped if the queue is full,
+// the rate limit is reached or the sender is closed.
+func (s *ReportSender) Send(r *ViolationReport) {
+	if s.ctx.Err() != nil || !s.allow() {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	select {
+	case s.queue <- r:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Stats returns the number of the sent, dropped and failed (after retries) reports.
+func (s *ReportSender) Stats() (sent, dropped, failed uint64) {
+	return atomic.LoadUint64(&s.sent), atomic.LoadUint64(&s.dropped), atomic.LoadUint64(&s.failed)
+}
+
+// Close stops the sender, the queued reports are dropped.
+func (s *ReportSender) Close() error {
+	s.once.Do(s.cancel)
+	<-s.done
+	return nil
+}
+
+// allow takes the token of the rate limit.
+func (s *ReportSender) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.cfg.RateLimit
+	if max := float64(s.cfg.RateBurst); s.tokens > max {
+		s.tokens = max
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (s *ReportSender) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case r := <-s.queue:
+			if err := s.post(r); err != nil {
+				atomic.AddUint64(&s.failed, 1)
+				continue
+			}
+			atomic.AddUint64(&s.sent, 1)
+		}
+	}
+}
+
+// post sends the report with the retries.
+func (s *ReportSender) post(r *ViolationReport) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal report")
+	}
+
+	backoff := s.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = s.postOnce(body)
+		if err == nil || attempt >= s.cfg.MaxRetries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *ReportSender) postOnce(body []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return &statusError{code: res.StatusCode}
+	}
+	return nil
+}
+
+// statusError is the unexpected status of the collector.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + strconv.Itoa(e.code)
+}
+
+// retryable reports whether the POST is retried: only the server and the network errors are.
+func retryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500
+	}
+	return true
+}
+
+func newViolationReport(e Event) *ViolationReport {
+	r := &ViolationReport{
+		Time:     time.Now().UTC(),
+		Hostname: e.ServerName,
+		Enforced: e.Err != nil,
+	}
+	if host, port, err := net.SplitHostPort(e.Addr); err == nil {
+		if r.Hostname == "" {
+			r.Hostname = host
+		}
+		r.Port, _ = strconv.Atoi(port)
+	}
+	for _, pin := range e.KnownPins {
+		if strings.HasPrefix(pin, "sha256/") {
+			r.KnownPins = append(r.KnownPins, `pin-sha256="`+strings.TrimPrefix(pin, "sha256/")+`"`)
+		} else {
+			r.KnownFingerprints = append(r.KnownFingerprints, pin)
+		}
+	}
+	if e.Err != nil {
+		r.Reasons = append(r.Reasons, e.Err.Error())
+	}
+	// the result is nil if the presented chain is not parsed
+	if e.Result == nil {
+		return r
+	}
+	for _, cert := range e.Result.PeerCertificates {
+		r.ServedCertificateChain = append(r.ServedCertificateChain, string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		})))
+	}
+	for _, err := range e.Result.Violations {
+		r.Reasons = append(r.Reasons, err.Error())
+	}
+	return r
+}
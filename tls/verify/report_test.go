@@ -0,0 +1,180 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportSender(t *testing.T) {
+	const fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
+
+	t.Run("report", func(t *testing.T) {
+		reports := make(chan ViolationReport, 1)
+		var calls int32
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var report ViolationReport
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+			reports <- report
+		}))
+		defer collector.Close()
+
+		sender := NewReportSender(ReportSenderConfig{URL: collector.URL, RetryBackoff: time.Millisecond})
+		defer sender.Close()
+
+		leaf := newTestLeaf(t, nil, "localhost")
+		otherPin := CertFingerprints(newTestLeaf(t, nil, "localhost").cert).SPKISHA256
+		addr := serveTLS(t, leaf.tlsCertificate())
+		d, err := NewDialer(SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred), PinSHA256("sha256/"+otherPin), Observer(sender.Observe))
+		require.NoError(t, err)
+		_, err = d.DialContext(context.Background(), "tcp", addr)
+		require.ErrorIs(t, err, ErrNotMatchedFingerprint)
+
+		select {
+		case report := <-reports:
+			assert.Equal(t, "127.0.0.1", report.Hostname)
+			assert.Equal(t, strconv.Itoa(report.Port), addr[len("127.0.0.1:"):])
+			assert.Equal(t, []string{`pin-sha256="` + otherPin + `"`}, report.KnownPins)
+			assert.Equal(t, []string{"sha1/" + fingerprintNoRegistred}, report.KnownFingerprints)
+			assert.Equal(t, []string{ErrNotMatchedFingerprint.Error()}, report.Reasons)
+			assert.True(t, report.Enforced)
+			require.Len(t, report.ServedCertificateChain, 1)
+			block, _ := pem.Decode([]byte(report.ServedCertificateChain[0]))
+			require.NotNil(t, block)
+			assert.Equal(t, leaf.cert.Raw, block.Bytes)
+		case <-time.After(5 * time.Second):
+			t.Fatal("report not received")
+		}
+		assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("unparsableChain", func(t *testing.T) {
+		reports := make(chan ViolationReport, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var report ViolationReport
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+			reports <- report
+		}))
+		defer collector.Close()
+
+		sender := NewReportSender(ReportSenderConfig{URL: collector.URL})
+		defer sender.Close()
+
+		v, err := NewTLSVerifyPeerCertificate(DNSName("example.com"), ReportTo(sender))
+		require.NoError(t, err)
+		require.Error(t, v.Option()([][]byte{[]byte("garbage")}, nil))
+
+		select {
+		case report := <-reports:
+			assert.Equal(t, "example.com", report.Hostname)
+			assert.Empty(t, report.ServedCertificateChain)
+			require.Len(t, report.Reasons, 1)
+			assert.Contains(t, report.Reasons[0], "failed to parse certificate")
+			assert.True(t, report.Enforced)
+		case <-time.After(5 * time.Second):
+			t.Fatal("report not received")
+		}
+	})
+
+	t.Run("clientError", func(t *testing.T) {
+		var calls int32
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer collector.Close()
+
+		sender := NewReportSender(ReportSenderConfig{URL: collector.URL, RetryBackoff: time.Millisecond})
+		defer sender.Close()
+		sender.Send(&ViolationReport{})
+		assert.Eventually(t, func() bool {
+			_, _, failed := sender.Stats()
+			return failed == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "4xx should not be retried")
+	})
+
+	t.Run("wrapTransport", func(t *testing.T) {
+		reports := make(chan ViolationReport, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var report ViolationReport
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+			reports <- report
+		}))
+		defer collector.Close()
+
+		sender := NewReportSender(ReportSenderConfig{URL: collector.URL})
+		defer sender.Close()
+
+		leaf := newTestLeaf(t, nil, "localhost")
+		srv := serveHTTPS(t, leaf.tlsCertificate())
+		port := srv.Listener.Addr().(*net.TCPAddr).Port
+		tr, err := WrapTransport(nil, SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred), ReportTo(sender))
+		require.NoError(t, err)
+		_, err = get(t, &http.Client{Transport: tr}, "https://localhost:"+strconv.Itoa(port))
+		require.True(t, errors.Is(err, ErrNotMatchedFingerprint), "got %v", err)
+
+		select {
+		case report := <-reports:
+			assert.Equal(t, "localhost", report.Hostname)
+			assert.Equal(t, port, report.Port)
+		case <-time.After(5 * time.Second):
+			t.Fatal("report not received")
+		}
+	})
+
+	t.Run("rateLimit", func(t *testing.T) {
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer collector.Close()
+
+		sender := NewReportSender(ReportSenderConfig{URL: collector.URL, RateLimit: 0.001, RateBurst: 1})
+		for i := 0; i < 3; i++ {
+			sender.Send(&ViolationReport{})
+		}
+		_, dropped, _ := sender.Stats()
+		assert.EqualValues(t, 2, dropped)
+		sender.Close()
+	})
+
+	t.Run("neverBlocks", func(t *testing.T) {
+		block := make(chan struct{})
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-block
+		}))
+		defer collector.Close()
+		defer close(block)
+
+		sender := NewReportSender(ReportSenderConfig{URL: collector.URL, QueueSize: 1, RateLimit: 1000, RateBurst: 1000})
+		defer sender.Close()
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 100; i++ {
+				sender.Send(&ViolationReport{})
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("blocked")
+		}
+		_, dropped, _ := sender.Stats()
+		assert.True(t, dropped >= 98, "dropped %d", dropped)
+	})
+}
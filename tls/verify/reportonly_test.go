@@ -0,0 +1,74 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportOnly(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	expired := issueTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "localhost"},
+		DNSNames:  []string{"localhost"},
+		NotBefore: time.Now().Add(-2 * time.Hour),
+		NotAfter:  time.Now().Add(-time.Hour),
+	}, root)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	const fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
+
+	t.Run("reportOnly", func(t *testing.T) {
+		var events []Event
+		v := TLSVerifyPeerCertificate(
+			RootCAs(roots),
+			FingerprintSHA1(fingerprintNoRegistred),
+			ReportOnly(),
+			Observer(func(e Event) { events = append(events, e) }),
+		)
+		assert.NoError(t, v.Option()(rawChain(expired, root), nil))
+		assert.NoError(t, v.Wait())
+
+		require.Len(t, events, 1)
+		assert.NoError(t, events[0].Err)
+		require.Len(t, events[0].Result.Violations, 2)
+		assert.ErrorIs(t, events[0].Result.Violations[0], ErrCertExpired)
+		assert.ErrorIs(t, events[0].Result.Violations[1], ErrNotMatchedFingerprint)
+		assert.Empty(t, events[0].Result.Checks)
+	})
+	t.Run("enforce", func(t *testing.T) {
+		var events []Event
+		v := TLSVerifyPeerCertificate(
+			RootCAs(roots),
+			FingerprintSHA1(fingerprintNoRegistred),
+			Observer(func(e Event) { events = append(events, e) }),
+		)
+		assert.ErrorIs(t, v.Option()(rawChain(expired, root), nil), ErrCertExpired)
+
+		require.Len(t, events, 1)
+		assert.ErrorIs(t, events[0].Err, ErrCertExpired)
+		assert.Empty(t, events[0].Result.Violations)
+	})
+	t.Run("Dialer", func(t *testing.T) {
+		addr := serveTLS(t, expired.tlsCertificate(root))
+		var events []Event
+		d, err := NewDialer(RootCAs(roots), ReportOnly(), Observer(func(e Event) { events = append(events, e) }))
+		require.NoError(t, err)
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		res, ok := ConnResult(conn)
+		require.True(t, ok)
+		require.Len(t, res.Violations, 1)
+		assert.ErrorIs(t, res.Violations[0], ErrCertExpired)
+		require.Len(t, events, 1)
+		assert.Equal(t, addr, events[0].Addr)
+		assert.Equal(t, "127.0.0.1", events[0].ServerName)
+	})
+}
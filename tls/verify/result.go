@@ -0,0 +1,82 @@
+package verify
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// The names of the checks in Result.Checks.
+const (
+	CheckChain       = "chain"
+	CheckHostname    = "hostname"
+	CheckDANE        = "dane"
+	CheckFingerprint = "fingerprint"
+	CheckPin         = "pin"
+	CheckIdentity    = "identity"
+	CheckSPIFFE      = "spiffe"
+)
+
+// Result is the outcome of the verification of the presented chain.
+type Result struct {
+	// PeerCertificates is the chain presented by the peer, leaf first.
+	PeerCertificates []*x509.Certificate
+	// VerifiedChains is filled if the chain verification was not skipped.
+	VerifiedChains [][]*x509.Certificate
+
+	// FingerprintSHA1 and FingerprintSHA256 are the hex of the hash of the leaf cert.
+	FingerprintSHA1   string
+	FingerprintSHA256 string
+	// SPKIPin is the base64 of the SHA-256 of the leaf public key (RFC 7469).
+	SPKIPin  string
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+	// SPIFFEID is the authenticated SPIFFE ID of the peer if the SPIFFE bundles are set.
+	SPIFFEID string
+
+	// Checks are the names of the passed checks.
+	Checks []string
+	// Violations are the errors of the failed checks in the report-only mode,
+	// the handshake would have failed because of them.
+	Violations []error
+}
+
+// Event is the outcome of the verification passed to the observers.
+type Event struct {
+	// Addr is the dialed address, it is empty if unknown (e.g. for TLSConfig).
+	Addr string
+	// ServerName is the checked name of the server.
+	ServerName string
+	// KnownPins are the expected pins in the form "algorithm/value".
+	KnownPins []string
+	// Result is nil if the presented certs are not parsed.
+	Result *Result
+	// Err fails the handshake. It is nil in the report-only mode.
+	Err error
+	// Chain is the chain of the failed verification (or with violations) if CaptureChain is set.
+	Chain *CapturedChain
+}
+
+func newResult(certs []*x509.Certificate) *Result {
+	leaf := certs[0]
+	f := CertFingerprints(leaf)
+	return &Result{
+		PeerCertificates:  certs,
+		FingerprintSHA1:   f.SHA1,
+		FingerprintSHA256: f.SHA256,
+		SPKIPin:           f.SPKISHA256,
+		Subject:           leaf.Subject.String(),
+		Issuer:            leaf.Issuer.String(),
+		NotAfter:          leaf.NotAfter,
+	}
+}
+
+// Passed reports whether the check is passed.
+func (r *Result) Passed(check string) bool {
+	for _, name := range r.Checks {
+		if name == check {
+			return true
+		}
+	}
+	return false
+}
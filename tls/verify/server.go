@@ -0,0 +1,104 @@
+package verify
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// ServerTLSConfig returns the server TLS config that requires the client cert
+// and verifies it like TLSVerifyPeerCertificate (for the client auth usage).
+// The ClientCAs of the config are used if the RootCAs option is not set,
+// add the Certificates of the server to the config.
+// The hostname is checked only if the DNSName option is set.
+func ServerTLSConfig(opts ...Option) (*tls.Config, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return v.serverTLSConfig(context.Background(), nil, nil), nil
+}
+
+// serverTLSConfig returns the clone of base with the verification of the client cert.
+// The onResult (if not nil) is called after each verification.
+func (v *tlsVerifyPeerCertificate) serverTLSConfig(ctx context.Context, base *tls.Config, onResult func(*Result, error)) *tls.Config {
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	baseVerifyConnection := cfg.VerifyConnection
+
+	// the client cert is verified by the options only
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		opts := *v.options()
+		opts.clientCerts = true
+		if opts.RootCAs == nil {
+			opts.RootCAs = cfg.ClientCAs
+		}
+		return v.verifyConnection(ctx, &opts, cs, onResult, baseVerifyConnection)
+	}
+	return cfg
+}
+
+// Listener accepts the TLS connections with the verification of the client certs
+// (see ServerTLSConfig). The result of the verification is available by ConnResult
+// after the handshake, for the http.Server by ResultFromRequest.
+type Listener struct {
+	net.Listener
+
+	config *tls.Config
+	v      *tlsVerifyPeerCertificate
+}
+
+// NewListener returns the Listener over inner. The config has the Certificates of the server,
+// set its NextProtos to "h2" and "http/1.1" to serve HTTP/2.
+func NewListener(inner net.Listener, config *tls.Config, opts ...Option) (*Listener, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{Listener: inner, config: config, v: v}, nil
+}
+
+// Accept returns the *tls.Conn, the handshake is run on the first read or write.
+func (l *Listener) Accept() (net.Conn, error) {
+	rawConn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ctx := contextWithAddr(context.Background(), rawConn.RemoteAddr().String())
+	rc := &resultConn{Conn: rawConn}
+	var conn *tls.Conn
+	conn = tls.Server(rc, l.v.serverTLSConfig(ctx, l.config, func(res *Result, err error) {
+		if err == nil && res != nil {
+			connResults.Store(conn, res)
+		}
+	}))
+	rc.tlsConn = conn
+	return conn, nil
+}
+
+// Update replaces the options of the verifier (e.g. by the rotated SPIFFE bundles),
+// the connections accepted after the update are verified by the new options.
+func (l *Listener) Update(opts ...Option) error {
+	return l.v.Update(opts...)
+}
+
+type contextConnKey struct{}
+
+// ConnContext is the http.Server.ConnContext that keeps the connection for ResultFromRequest.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, contextConnKey{}, c)
+}
+
+// ResultFromRequest returns the result of the verification of the client cert
+// of the request served by the http.Server over the Listener with ConnContext.
+func ResultFromRequest(r *http.Request) (*Result, bool) {
+	conn, ok := r.Context().Value(contextConnKey{}).(*tls.Conn)
+	if !ok {
+		return nil, false
+	}
+	return ConnResult(conn)
+}
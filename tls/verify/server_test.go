@@ -0,0 +1,64 @@
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerTLSConfig(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	server := newTestLeaf(t, root, "localhost")
+	client := issueTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, root)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(root.cert)
+
+	var events []Event
+	cfg, err := ServerTLSConfig(AllowedIdentities(SubjectCN(ExactMatch("client"))), Observer(func(e Event) { events = append(events, e) }))
+	require.NoError(t, err)
+	cfg.Certificates = []tls.Certificate{server.tlsCertificate()}
+	cfg.ClientCAs = clientCAs
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	// not net.Pipe: the client and the server may write the alerts at the same time
+	handshake := func(cert *testCert) error {
+		clientCfg := &tls.Config{InsecureSkipVerify: true}
+		if cert != nil {
+			clientCfg.Certificates = []tls.Certificate{cert.tlsCertificate()}
+		}
+		go func() {
+			conn, err := tls.Dial("tcp", lis.Addr().String(), clientCfg)
+			if err == nil {
+				// the server verifies the client cert after the client handshake (TLS 1.3)
+				conn.Read(make([]byte, 1))
+				conn.Close()
+			}
+		}()
+		conn, err := lis.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		return tls.Server(conn, cfg).Handshake()
+	}
+
+	require.NoError(t, handshake(client))
+	require.Len(t, events, 1)
+	assert.Equal(t, []string{CheckChain, CheckIdentity}, events[0].Result.Checks)
+
+	// the server cert is not for the client auth
+	err = handshake(server)
+	var invalidErr x509.CertificateInvalidError
+	assert.True(t, errors.As(err, &invalidErr), "got %v", err)
+	assert.EqualError(t, handshake(nil), "tls: client didn't provide a certificate")
+}
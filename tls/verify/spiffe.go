@@ -0,0 +1,254 @@
+package verify
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidSVID        = errors.New("invalid X.509-SVID")
+	ErrUnknownTrustDomain = errors.New("unknown SPIFFE trust domain")
+)
+
+// SPIFFEID is the SPIFFE ID "spiffe://<trust domain>/<path>".
+type SPIFFEID struct {
+	TrustDomain string
+	// Path is empty or starts with "/".
+	Path string
+}
+
+// ParseSPIFFEID parses the SPIFFE ID by the SPIFFE-ID spec: the trust domain
+// is in lower case, the path segments are not empty, "." or "..",
+// there is no port, user info, query or fragment.
+func ParseSPIFFEID(s string) (SPIFFEID, error) {
+	const scheme = "spiffe://"
+	if !strings.HasPrefix(s, scheme) {
+		return SPIFFEID{}, errors.Errorf("invalid SPIFFE ID %q: scheme is not spiffe", s)
+	}
+	rest := s[len(scheme):]
+	var id SPIFFEID
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		id.TrustDomain, id.Path = rest[:i], rest[i:]
+	} else {
+		id.TrustDomain = rest
+	}
+	if err := validateTrustDomain(id.TrustDomain); err != nil {
+		return SPIFFEID{}, errors.Wrapf(err, "invalid SPIFFE ID %q", s)
+	}
+	if id.Path != "" {
+		for _, segment := range strings.Split(id.Path[1:], "/") {
+			switch segment {
+			case "":
+				return SPIFFEID{}, errors.Errorf("invalid SPIFFE ID %q: empty path segment", s)
+			case ".", "..":
+				return SPIFFEID{}, errors.Errorf("invalid SPIFFE ID %q: relative path segment", s)
+			}
+			for _, c := range segment {
+				if !isSPIFFEChar(c) && !(c >= 'A' && c <= 'Z') {
+					return SPIFFEID{}, errors.Errorf("invalid SPIFFE ID %q: invalid path character %q", s, c)
+				}
+			}
+		}
+	}
+	return id, nil
+}
+
+func (id SPIFFEID) String() string {
+	return "spiffe://" + id.TrustDomain + id.Path
+}
+
+func validateTrustDomain(td string) error {
+	if td == "" {
+		return errors.New("empty trust domain")
+	}
+	for _, c := range td {
+		if !isSPIFFEChar(c) {
+			return errors.Errorf("invalid trust domain character %q", c)
+		}
+	}
+	return nil
+}
+
+// isSPIFFEChar reports whether c is allowed in the trust domain (and the path).
+func isSPIFFEChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '-' || c == '_'
+}
+
+// SPIFFEBundle sets the trust bundle (the roots) of the SPIFFE trust domain, e.g. "example.org".
+// With the bundles the peer cert is verified as the X.509-SVID (see TrustedSVID)
+// instead of the chain verification, the hostname is not checked.
+func SPIFFEBundle(trustDomain string, roots *x509.CertPool) Option {
+	return func(opts *Options) {
+		// the map may be shared with the options the context options are applied over
+		bundles := make(map[string]*x509.CertPool, len(opts.SPIFFEBundles)+1)
+		for td, pool := range opts.SPIFFEBundles {
+			bundles[td] = pool
+		}
+		bundles[trustDomain] = roots
+		opts.SPIFFEBundles = bundles
+	}
+}
+
+// AllowedSPIFFEIDs adds the matchers of the allowed SPIFFE IDs, the ID should match one of them,
+// e.g. ExactMatch("spiffe://example.org/web") or PrefixMatch("spiffe://example.org/ns/prod/").
+// All the IDs of the trust domains of the bundles are allowed by default.
+func AllowedSPIFFEIDs(matchers ...StringMatcher) Option {
+	return func(opts *Options) {
+		opts.SPIFFEIDs = append(opts.SPIFFEIDs, matchers...)
+	}
+}
+
+// SVIDError is the error of the leaf that is not the valid X.509-SVID.
+// errors.Is reports true for ErrInvalidSVID.
+type SVIDError struct {
+	Reason string
+	Cert   *x509.Certificate
+}
+
+func (e *SVIDError) Error() string {
+	return ErrInvalidSVID.Error() + ": " + e.Reason
+}
+
+func (e *SVIDError) Unwrap() error {
+	return ErrInvalidSVID
+}
+
+// SPIFFEIDFromCert returns the SPIFFE ID of the X.509-SVID, the cert should have the only URI SAN.
+// The error is *SVIDError.
+func SPIFFEIDFromCert(cert *x509.Certificate) (SPIFFEID, error) {
+	if len(cert.URIs) != 1 {
+		return SPIFFEID{}, &SVIDError{Reason: "the certificate should have exactly one URI SAN", Cert: cert}
+	}
+	id, err := ParseSPIFFEID(cert.URIs[0].String())
+	if err != nil {
+		return SPIFFEID{}, &SVIDError{Reason: err.Error(), Cert: cert}
+	}
+	return id, nil
+}
+
+// TrustedSVID verifies the leaf as the X.509-SVID: it has the SPIFFE ID, it is not a CA and
+// the chain is verified to the bundle of the trust domain of the ID.
+// The ID should match one of the matchers if any (the error is *MatchError with the URI SAN matchers).
+// The unknown trust domain fails with ErrUnknownTrustDomain.
+// The passed check sets the SPIFFEID of the chain.
+func TrustedSVID(bundles map[string]*x509.CertPool, ids ...StringMatcher) Check {
+	return CheckFunc(func(ctx context.Context, chain *Chain) error {
+		leaf := chain.Leaf()
+		id, err := SPIFFEIDFromCert(leaf)
+		if err != nil {
+			return err
+		}
+		if leaf.IsCA {
+			return &SVIDError{Reason: "the leaf is a CA", Cert: leaf}
+		}
+		if leaf.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+			return &SVIDError{Reason: "the leaf has the key usage to sign certificates or CRLs", Cert: leaf}
+		}
+		roots := bundles[id.TrustDomain]
+		if roots == nil {
+			return &VerificationError{Err: ErrUnknownTrustDomain, Cert: leaf}
+		}
+		// the SVIDs are used for the client and the server auth
+		chains, err := verifyChain(chain.Certificates, roots, "", x509.ExtKeyUsageAny)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			allowed := false
+			for _, m := range ids {
+				if m.Match(id.String()) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				rejected := make([]CertMatcher, len(ids))
+				for i, m := range ids {
+					rejected[i] = URISAN(m)
+				}
+				return &VerificationError{Err: &MatchError{Rejected: rejected, Cert: leaf}, Cert: leaf}
+			}
+		}
+		chain.VerifiedChains = chains
+		chain.SPIFFEID = id.String()
+		chain.passed = append(chain.passed, CheckSPIFFE)
+		return nil
+	})
+}
+
+// LoadSPIFFEBundle reads the trust bundle from the file (see ParseSPIFFEBundle).
+func LoadSPIFFEBundle(file string) (*x509.CertPool, error) {
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read")
+	}
+	roots, err := ParseSPIFFEBundle(dat)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid bundle %q", file)
+	}
+	return roots, nil
+}
+
+// ParseSPIFFEBundle parses the trust bundle: the PEM certs or the SPIFFE bundle
+// in the JWK Set form, only the "x509-svid" keys are used.
+func ParseSPIFFEBundle(dat []byte) (*x509.CertPool, error) {
+	roots := x509.NewCertPool()
+	var n int
+	if trimmed := strings.TrimSpace(string(dat)); strings.HasPrefix(trimmed, "{") {
+		var set struct {
+			Keys []struct {
+				Use string   `json:"use"`
+				X5C []string `json:"x5c"`
+			} `json:"keys"`
+		}
+		if err := json.Unmarshal(dat, &set); err != nil {
+			return nil, errors.Wrap(err, "failed decode JWK Set")
+		}
+		for i, key := range set.Keys {
+			if key.Use != "x509-svid" {
+				continue
+			}
+			if len(key.X5C) != 1 {
+				return nil, errors.Errorf("key %d: x509-svid key should have exactly one certificate", i)
+			}
+			der, err := base64.StdEncoding.DecodeString(key.X5C[0])
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %d", i)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, errors.Wrapf(err, "key %d", i)
+			}
+			roots.AddCert(cert)
+			n++
+		}
+	} else {
+		for {
+			var block *pem.Block
+			block, dat = pem.Decode(dat)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "certificate %d", n)
+			}
+			roots.AddCert(cert)
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, errors.New("no certificates")
+	}
+	return roots, nil
+}
@@ -0,0 +1,297 @@
+package verify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSVID issues the X.509-SVID with the SPIFFE ID for the client and the server auth.
+func newTestSVID(t *testing.T, parent *testCert, ids ...string) *testCert {
+	t.Helper()
+
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"spiffe"}},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, id := range ids {
+		u, err := url.Parse(id)
+		require.NoError(t, err)
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	return issueTestCert(t, tmpl, parent)
+}
+
+func TestParseSPIFFEID(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SPIFFEID
+		wantErr string
+	}{
+		{"spiffe://example.org/ns/prod/web", SPIFFEID{"example.org", "/ns/prod/web"}, ""},
+		{"spiffe://example.org", SPIFFEID{"example.org", ""}, ""},
+		{"spiffe://my-domain_1.example/Web.v1", SPIFFEID{"my-domain_1.example", "/Web.v1"}, ""},
+
+		{"https://example.org/web", SPIFFEID{}, `invalid SPIFFE ID "https://example.org/web": scheme is not spiffe`},
+		{"spiffe:///web", SPIFFEID{}, `invalid SPIFFE ID "spiffe:///web": empty trust domain`},
+		{"spiffe://Example.org/web", SPIFFEID{}, `invalid SPIFFE ID "spiffe://Example.org/web": invalid trust domain character 'E'`},
+		{"spiffe://example.org:8443/web", SPIFFEID{}, `invalid SPIFFE ID "spiffe://example.org:8443/web": invalid trust domain character ':'`},
+		{"spiffe://user@example.org/web", SPIFFEID{}, `invalid SPIFFE ID "spiffe://user@example.org/web": invalid trust domain character '@'`},
+		{"spiffe://example.org/web/", SPIFFEID{}, `invalid SPIFFE ID "spiffe://example.org/web/": empty path segment`},
+		{"spiffe://example.org/ns//web", SPIFFEID{}, `invalid SPIFFE ID "spiffe://example.org/ns//web": empty path segment`},
+		{"spiffe://example.org/ns/../web", SPIFFEID{}, `invalid SPIFFE ID "spiffe://example.org/ns/../web": relative path segment`},
+		{"spiffe://example.org/web?x=1", SPIFFEID{}, `invalid SPIFFE ID "spiffe://example.org/web?x=1": invalid path character '?'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSPIFFEID(tt.in)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.in, got.String())
+		})
+	}
+}
+
+func TestParseSPIFFEBundle(t *testing.T) {
+	root := newTestCA(t, "example.org", nil)
+	other := newTestCA(t, "other", nil)
+	svid := newTestSVID(t, root, "spiffe://example.org/web")
+
+	verifies := func(t *testing.T, roots *x509.CertPool) {
+		t.Helper()
+		_, err := svid.cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		assert.NoError(t, err)
+	}
+
+	t.Run("pem", func(t *testing.T) {
+		dat := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.cert.Raw})
+		dat = append(dat, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})...)
+		roots, err := ParseSPIFFEBundle(dat)
+		require.NoError(t, err)
+		verifies(t, roots)
+	})
+	t.Run("jwks", func(t *testing.T) {
+		dat, err := json.Marshal(map[string]interface{}{
+			"spiffe_sequence": 1,
+			"keys": []map[string]interface{}{
+				{"use": "jwt-svid", "kty": "EC", "kid": "a"},
+				{"use": "x509-svid", "kty": "EC", "x5c": []string{base64.StdEncoding.EncodeToString(root.cert.Raw)}},
+			},
+		})
+		require.NoError(t, err)
+		file := filepath.Join(t.TempDir(), "bundle.json")
+		require.NoError(t, ioutil.WriteFile(file, dat, 0600))
+		roots, err := LoadSPIFFEBundle(file)
+		require.NoError(t, err)
+		verifies(t, roots)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ParseSPIFFEBundle([]byte(`{"keys":[{"use":"jwt-svid"}]}`))
+		assert.EqualError(t, err, "no certificates")
+		_, err = ParseSPIFFEBundle([]byte(`{"keys":[{"use":"x509-svid","x5c":["a","b"]}]}`))
+		assert.EqualError(t, err, "key 0: x509-svid key should have exactly one certificate")
+		_, err = ParseSPIFFEBundle([]byte("not a bundle"))
+		assert.EqualError(t, err, "no certificates")
+		_, err = LoadSPIFFEBundle(filepath.Join(t.TempDir(), "missing.pem"))
+		assert.Error(t, err)
+	})
+}
+
+func TestTrustedSVID(t *testing.T) {
+	root := newTestCA(t, "example.org", nil)
+	intermediate := newTestCA(t, "example.org intermediate", root)
+	otherRoot := newTestCA(t, "other.org", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot.cert)
+
+	web := newTestSVID(t, intermediate, "spiffe://example.org/ns/prod/web")
+	// the SVIDs are verified for any usage
+	client := issueTestCert(t, &x509.Certificate{
+		URIs:        []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/ns/prod/client"}},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, root)
+
+	verify := func(chain [][]byte, opts ...Option) (*Result, error) {
+		var res *Result
+		opts = append(opts, Observer(func(e Event) { res = e.Result }))
+		err := TLSVerifyPeerCertificate(opts...).Option()(chain, nil)
+		return res, err
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		res, err := verify(rawChain(web, intermediate), SPIFFEBundle("example.org", roots), SPIFFEBundle("other.org", otherRoots), DNSName("localhost"))
+		require.NoError(t, err)
+		assert.Equal(t, "spiffe://example.org/ns/prod/web", res.SPIFFEID)
+		assert.Equal(t, []string{CheckSPIFFE}, res.Checks)
+		require.Len(t, res.VerifiedChains, 1)
+		assert.Len(t, res.VerifiedChains[0], 3)
+
+		res, err = verify(rawChain(client), SPIFFEBundle("example.org", roots), AllowedSPIFFEIDs(ExactMatch("spiffe://example.org/ns/prod/client")))
+		require.NoError(t, err)
+		assert.Equal(t, "spiffe://example.org/ns/prod/client", res.SPIFFEID)
+	})
+	t.Run("notAllowed", func(t *testing.T) {
+		res, err := verify(rawChain(web, intermediate),
+			SPIFFEBundle("example.org", roots),
+			AllowedSPIFFEIDs(ExactMatch("spiffe://example.org/ns/prod/api"), PrefixMatch("spiffe://example.org/ns/dev/")))
+		assert.ErrorIs(t, err, ErrNotMatchedIdentity)
+		assert.Empty(t, res.SPIFFEID)
+		assert.Empty(t, res.Checks)
+		assert.Equal(t, `Certificate "O=spiffe" is rejected by the identity matchers: `+
+			`uri_san exact "spiffe://example.org/ns/prod/api" (seen ["spiffe://example.org/ns/prod/web"]), `+
+			`uri_san prefix "spiffe://example.org/ns/dev/" (seen ["spiffe://example.org/ns/prod/web"]).`, Explain(err))
+
+		_, err = verify(rawChain(web, intermediate), SPIFFEBundle("example.org", roots), AllowedSPIFFEIDs(PrefixMatch("spiffe://example.org/ns/prod/")))
+		assert.NoError(t, err)
+	})
+	t.Run("unknownTrustDomain", func(t *testing.T) {
+		_, err := verify(rawChain(web, intermediate), SPIFFEBundle("other.org", roots))
+		assert.ErrorIs(t, err, ErrUnknownTrustDomain)
+		assert.Equal(t, `There is no SPIFFE trust bundle for the trust domain of certificate "O=spiffe" (spiffe://example.org/ns/prod/web).`, Explain(err))
+	})
+	t.Run("untrusted", func(t *testing.T) {
+		// the bundle of the other domain does not verify the SVID
+		_, err := verify(rawChain(web, intermediate), SPIFFEBundle("example.org", otherRoots))
+		var authErr x509.UnknownAuthorityError
+		assert.True(t, errors.As(err, &authErr), "got %v", err)
+	})
+	t.Run("invalidSVID", func(t *testing.T) {
+		tests := []struct {
+			cert   *testCert
+			reason string
+		}{
+			{newTestLeaf(t, root, "localhost"), "the certificate should have exactly one URI SAN"},
+			{newTestSVID(t, root, "spiffe://example.org/a", "spiffe://example.org/b"), "the certificate should have exactly one URI SAN"},
+			{newTestSVID(t, root, "https://example.org/web"), `invalid SPIFFE ID "https://example.org/web": scheme is not spiffe`},
+			{issueTestCert(t, &x509.Certificate{
+				URIs:                  []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/ca"}},
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign,
+			}, root), "the leaf is a CA"},
+		}
+		for _, tt := range tests {
+			_, err := verify(rawChain(tt.cert), SPIFFEBundle("example.org", roots))
+			assert.ErrorIs(t, err, ErrInvalidSVID)
+			var svidErr *SVIDError
+			require.True(t, errors.As(err, &svidErr))
+			assert.Equal(t, tt.reason, svidErr.Reason)
+			assert.Contains(t, Explain(err), "is not a valid SPIFFE X.509-SVID: "+tt.reason)
+		}
+	})
+	t.Run("invalidOptions", func(t *testing.T) {
+		_, err := TLSConfig(AllowedSPIFFEIDs(ExactMatch("spiffe://example.org/web")))
+		assert.EqualError(t, err, "allowed SPIFFE IDs without SPIFFE bundles")
+		_, err = TLSConfig(SPIFFEBundle("Example.org", roots))
+		assert.EqualError(t, err, `invalid SPIFFE bundle "Example.org": invalid trust domain character 'E'`)
+		_, err = TLSConfig(SPIFFEBundle("example.org", nil))
+		assert.EqualError(t, err, `SPIFFE bundle "example.org" has no roots`)
+		_, err = TLSConfig(SPIFFEBundle("example.org", roots), AllowedSPIFFEIDs(RegexMatch("(")))
+		assert.Error(t, err)
+		_, err = TLSConfig(SPIFFEBundle("example.org", roots), DANE(StaticTLSAResolver{}, "example.org", 443))
+		assert.EqualError(t, err, "DANE and SPIFFE bundles are exclusive")
+	})
+}
+
+func TestSPIFFE_mTLS(t *testing.T) {
+	root := newTestCA(t, "example.org", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	serverSVID := newTestSVID(t, root, "spiffe://example.org/server")
+	clientSVID := newTestSVID(t, root, "spiffe://example.org/ns/prod/client")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tlsLis, err := NewListener(lis, &tls.Config{
+		Certificates: []tls.Certificate{serverSVID.tlsCertificate()},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, SPIFFEBundle("example.org", roots), AllowedSPIFFEIDs(PrefixMatch("spiffe://example.org/ns/prod/")))
+	require.NoError(t, err)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, ok := ResultFromRequest(r)
+			if !ok {
+				http.Error(w, "no result", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(res.SPIFFEID))
+		}),
+		ConnContext: ConnContext,
+	}
+	go srv.Serve(tlsLis)
+	t.Cleanup(func() { srv.Close() })
+	addr := "https://" + lis.Addr().String()
+
+	newClient := func(t *testing.T, cert *testCert) *http.Client {
+		t.Helper()
+		base := http.DefaultTransport.(*http.Transport).Clone()
+		base.TLSClientConfig = &tls.Config{}
+		if cert != nil {
+			base.TLSClientConfig.Certificates = []tls.Certificate{cert.tlsCertificate()}
+		}
+		rt, err := NewRoundTripper(base, SPIFFEBundle("example.org", roots), AllowedSPIFFEIDs(ExactMatch("spiffe://example.org/server")))
+		require.NoError(t, err)
+		return &http.Client{Transport: rt}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		res, err := newClient(t, clientSVID).Get(addr)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		dat, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "spiffe://example.org/ns/prod/client", string(dat))
+		assert.Equal(t, "HTTP/2.0", res.Proto)
+
+		result, ok := ResultFromResponse(res)
+		require.True(t, ok)
+		assert.Equal(t, "spiffe://example.org/server", result.SPIFFEID)
+	})
+	t.Run("clientNotAllowed", func(t *testing.T) {
+		_, err := newClient(t, newTestSVID(t, root, "spiffe://example.org/ns/dev/client")).Get(addr)
+		assert.Error(t, err)
+	})
+	t.Run("noClientCert", func(t *testing.T) {
+		_, err := newClient(t, nil).Get(addr)
+		assert.Error(t, err)
+	})
+	t.Run("serverNotAllowed", func(t *testing.T) {
+		base := http.DefaultTransport.(*http.Transport).Clone()
+		base.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{clientSVID.tlsCertificate()}}
+		rt, err := NewRoundTripper(base, SPIFFEBundle("example.org", roots), AllowedSPIFFEIDs(ExactMatch("spiffe://example.org/api")))
+		require.NoError(t, err)
+		_, err = (&http.Client{Transport: rt}).Get(addr)
+		assert.ErrorIs(t, err, ErrNotMatchedIdentity)
+	})
+	t.Run("httpClient", func(t *testing.T) {
+		srv := serveHTTPS(t, serverSVID.tlsCertificate())
+		c := HttpClient(SPIFFEBundle("example.org", roots), AllowedSPIFFEIDs(ExactMatch("spiffe://example.org/server")))
+		res, err := get(t, c, srv.URL)
+		require.NoError(t, err)
+		result, ok := ResultFromResponse(res)
+		require.True(t, ok)
+		assert.Equal(t, "spiffe://example.org/server", result.SPIFFEID)
+		assert.True(t, result.Passed(CheckSPIFFE))
+	})
+}
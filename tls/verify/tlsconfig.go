@@ -0,0 +1,156 @@
+package verify
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig returns the client TLS config with the verification of the server cert.
+// The server name of the connection is checked if the DNSName option is not set.
+func TLSConfig(opts ...Option) (*tls.Config, error) {
+	v, err := newVerifier(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return v.tlsConfig(context.Background(), nil, "", nil), nil
+}
+
+func newVerifier(opts ...Option) (*tlsVerifyPeerCertificate, error) {
+	v := TLSVerifyPeerCertificate(opts...)
+	if err := v.options().validate(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (o *Options) validate() error {
+	if o.TLSAResolver != nil && o.TLSAPort <= 0 {
+		return errors.New("invalid TLSA port")
+	}
+	if o.SHA1Fingerprint != "" {
+		if _, err := parseFingerprintOf("sha1", o.SHA1Fingerprint); err != nil {
+			return err
+		}
+	}
+	if _, err := o.pinsSHA256(); err != nil {
+		return err
+	}
+	if len(o.SPIFFEBundles) > 0 && o.TLSAResolver != nil {
+		return errors.New("DANE and SPIFFE bundles are exclusive")
+	}
+	for td, roots := range o.SPIFFEBundles {
+		if err := validateTrustDomain(td); err != nil {
+			return errors.Wrapf(err, "invalid SPIFFE bundle %q", td)
+		}
+		if roots == nil {
+			return errors.Errorf("SPIFFE bundle %q has no roots", td)
+		}
+	}
+	if len(o.SPIFFEIDs) > 0 && len(o.SPIFFEBundles) == 0 {
+		return errors.New("allowed SPIFFE IDs without SPIFFE bundles")
+	}
+	for _, m := range o.SPIFFEIDs {
+		if err := m.validate(); err != nil {
+			return errors.Wrap(err, "invalid SPIFFE ID matcher")
+		}
+	}
+	for _, matchers := range o.Identities {
+		if len(matchers) == 0 {
+			return errors.New("no identity matchers")
+		}
+		for _, m := range matchers {
+			if err := m.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// forServerName returns the options for the handshake with the server.
+// The server name is used as DNSName if the last is not set.
+func (o *Options) forServerName(serverName string) *Options {
+	opts := *o
+	if opts.DNSName == "" {
+		opts.DNSName = serverName
+	}
+	return &opts
+}
+
+// tlsConfig returns the clone of base with the verification of the server cert.
+// The RootCAs of base are used if the RootCAs option is not set.
+// The serverName is checked, if empty the server name of the connection is checked.
+// The onResult (if not nil) is called after each verification.
+func (v *tlsVerifyPeerCertificate) tlsConfig(ctx context.Context, base *tls.Config, serverName string, onResult func(*Result, error)) *tls.Config {
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if serverName != "" && cfg.ServerName == "" {
+		cfg.ServerName = serverName
+	}
+	baseVerifyConnection := cfg.VerifyConnection
+
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		name := serverName
+		if name == "" {
+			name = cs.ServerName
+		}
+		opts := v.options().forServerName(name)
+		if opts.RootCAs == nil {
+			opts.RootCAs = cfg.RootCAs
+		}
+		return v.verifyConnection(ctx, opts, cs, onResult, baseVerifyConnection)
+	}
+	return cfg
+}
+
+// verifyConnection verifies the peer certs of the connection by the opts
+// and then by the VerifyConnection of the base config if any.
+func (v *tlsVerifyPeerCertificate) verifyConnection(ctx context.Context, opts *Options, cs tls.ConnectionState, onResult func(*Result, error), base func(tls.ConnectionState) error) error {
+	rawCerts := make([][]byte, len(cs.PeerCertificates))
+	for i, cert := range cs.PeerCertificates {
+		rawCerts[i] = cert.Raw
+	}
+	res, err := v.verify(ctx, opts, rawCerts)
+	if onResult != nil {
+		onResult(res, err)
+	}
+	if err != nil {
+		return err
+	}
+	if base != nil {
+		return base(cs)
+	}
+	return nil
+}
+
+// handshake runs the client handshake until the ctx is done.
+func handshake(ctx context.Context, conn *tls.Conn) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- conn.Handshake()
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			conn.Close()
+		}
+		return err
+	case <-ctx.Done():
+		conn.Close()
+		return ctx.Err()
+	}
+}
+
+// hostname returns the host of addr.
+func hostname(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,176 @@
+package verify
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdate(t *testing.T) {
+	leaf := newTestLeaf(t, nil, "localhost")
+	const fingerprintNoRegistred = "81f344a7686a80b4c5293e8fdc0b0160c82c06a8"
+
+	t.Run("verifier", func(t *testing.T) {
+		v := TLSVerifyPeerCertificate(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+		verify := v.Option()
+		assert.NoError(t, verify(rawChain(leaf), nil))
+
+		require.NoError(t, v.Update(SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred)))
+		assert.ErrorIs(t, verify(rawChain(leaf), nil), ErrNotMatchedFingerprint)
+
+		assert.Error(t, v.Update(DANE(StaticTLSAResolver{}, "localhost", 0)))
+		assert.ErrorIs(t, verify(rawChain(leaf), nil), ErrNotMatchedFingerprint, "should keep the options on the invalid update")
+	})
+
+	t.Run("client", func(t *testing.T) {
+		srv := serveHTTPS(t, leaf.tlsCertificate())
+		c := HttpClient(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+
+		_, err := get(t, c, srv.URL)
+		require.NoError(t, err)
+
+		require.NoError(t, UpdateClient(c, SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred)))
+		_, err = get(t, c, srv.URL)
+		assert.True(t, errors.Is(err, ErrNotMatchedFingerprint), "pooled connection should not be reused, got %v", err)
+
+		require.NoError(t, UpdateClient(c, SkipTLSVerify(), FingerprintSHA1(leaf.sha1())))
+		_, err = get(t, c, srv.URL)
+		assert.NoError(t, err)
+
+		assert.Error(t, UpdateClient(http.DefaultClient))
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		srv := serveHTTPS(t, leaf.tlsCertificate())
+		c := HttpClient(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					res, err := c.Get(srv.URL)
+					if err == nil {
+						res.Body.Close()
+					}
+				}
+			}()
+		}
+		for j := 0; j < 10; j++ {
+			require.NoError(t, UpdateClient(c, SkipTLSVerify(), FingerprintSHA1(leaf.sha1())))
+		}
+		wg.Wait()
+	})
+
+	t.Run("inflight", func(t *testing.T) {
+		entered, unblock := make(chan struct{}), make(chan struct{})
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				close(entered)
+				<-unblock
+			}
+			w.Write([]byte("ok"))
+		}))
+		var (
+			mu     sync.Mutex
+			closed int
+		)
+		srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateClosed {
+				mu.Lock()
+				closed++
+				mu.Unlock()
+			}
+		}
+		srv.EnableHTTP2 = true
+		srv.TLS = &tls.Config{Certificates: []tls.Certificate{leaf.tlsCertificate()}}
+		srv.StartTLS()
+		defer srv.Close()
+		c := HttpClient(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+
+		errc := make(chan error, 1)
+		go func() {
+			res, err := c.Get(srv.URL + "/slow")
+			if err == nil {
+				_, err = ioutil.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+		<-entered
+		require.NoError(t, UpdateClient(c, SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred)))
+		close(unblock)
+		require.NoError(t, <-errc)
+
+		// the connection of the previous options is closed after the request
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return closed == 1
+		}, 3*time.Second, 10*time.Millisecond)
+		_, err := get(t, c, srv.URL)
+		assert.ErrorIs(t, err, ErrNotMatchedFingerprint)
+	})
+
+	t.Run("revokedPin", func(t *testing.T) {
+		srv := serveHTTPS(t, leaf.tlsCertificate())
+		c := HttpClient(SkipTLSVerify(), FingerprintSHA1(leaf.sha1()))
+		rt := c.Transport.(*RoundTripper)
+		// the context options without the pins, the pin is of the client
+		ctx := ContextWithOptions(context.Background(), Checks(CheckFunc(func(context.Context, *Chain) error { return nil })))
+		do := func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+			require.NoError(t, err)
+			res, err := c.Do(req)
+			if err != nil {
+				return err
+			}
+			ioutil.ReadAll(res.Body)
+			return res.Body.Close()
+		}
+		require.NoError(t, do())
+
+		// the transport picked before the update is not used after it
+		picked := rt.forContext(ctx)
+		require.NoError(t, UpdateClient(c, SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred)))
+		assert.Nil(t, picked.acquire())
+		assert.ErrorIs(t, do(), ErrNotMatchedFingerprint)
+		require.NoError(t, UpdateClient(c, SkipTLSVerify(), FingerprintSHA1(leaf.sha1())))
+		require.NoError(t, do())
+
+		// the requests started after the update are verified by the new options
+		var (
+			updated int32
+			wg      sync.WaitGroup
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					after := atomic.LoadInt32(&updated) == 1
+					err := do()
+					if after {
+						assert.ErrorIs(t, err, ErrNotMatchedFingerprint)
+					}
+				}
+			}()
+		}
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, UpdateClient(c, SkipTLSVerify(), FingerprintSHA1(fingerprintNoRegistred)))
+		atomic.StoreInt32(&updated, 1)
+		wg.Wait()
+	})
+}
@@ -0,0 +1,231 @@
+package verifytest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gebv/go-lib/tls/minica"
+	"github.com/gebv/go-lib/tls/verify/recording"
+	"github.com/pkg/errors"
+)
+
+// ReplayMode is how the replay server presents the recorded chain.
+type ReplayMode int
+
+const (
+	// ReplayResigned presents the chain re-signed by the generated keys: all fields
+	// of the certs (names, validity, extensions) are kept, the keys and the signatures
+	// are not. The extensions x509 does not build from the fields (e.g. the SCTs)
+	// are copied as recorded. The root of the chain (or its issuer if the root is not presented)
+	// is replaced by the generated one the server Roots trust.
+	// The handshake succeeds, the pins of the recording do not match.
+	ReplayResigned ReplayMode = iota
+	// ReplayExact presents exactly the recorded bytes for the pin tests.
+	// The server does not own the key of the leaf, so the handshake fails
+	// after the verification of the peer certs (the verifier decides first).
+	// Roots is nil, the pins are of the recorded leaf.
+	ReplayExact
+)
+
+// NewReplayServer starts the TLS server presenting the recorded chain that writes "ok" to every connection.
+// The server is closed at the end of the test.
+func NewReplayServer(tb testing.TB, r *recording.Recording, mode ReplayMode) *Server {
+	tb.Helper()
+
+	s := newReplayServer(tb, r, mode)
+	s.serveTLS(tb)
+	return s
+}
+
+// NewReplayHTTPServer starts the HTTPS server presenting the recorded chain (see NewHTTPServer).
+func NewReplayHTTPServer(tb testing.TB, r *recording.Recording, mode ReplayMode, handler http.Handler) *Server {
+	tb.Helper()
+
+	s := newReplayServer(tb, r, mode)
+	s.serveHTTP(tb, handler)
+	return s
+}
+
+func newReplayServer(tb testing.TB, r *recording.Recording, mode ReplayMode) *Server {
+	tb.Helper()
+
+	s, err := replay(r, mode)
+	if err != nil {
+		tb.Fatalf("failed replay %s: %v", r.Addr, err)
+	}
+	return s
+}
+
+func replay(r *recording.Recording, mode ReplayMode) (*Server, error) {
+	certs, err := r.Certificates()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{}
+	switch mode {
+	case ReplayExact:
+		// the handshake is signed by the key of the same type to fail on the signature only
+		key, err := keyLike(certs[0].PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		s.Chain = certs
+		s.Leaf = &minica.Cert{Cert: certs[0], Key: key}
+	case ReplayResigned:
+		resigned, err := resign(certs)
+		if err != nil {
+			return nil, err
+		}
+		s.Root = resigned[len(resigned)-1]
+		s.Roots = x509.NewCertPool()
+		s.Roots.AddCert(s.Root.Cert)
+		s.Leaf = resigned[0]
+		if len(resigned) > 2 {
+			s.Intermediate = resigned[1]
+		}
+		// the generated root of the not presented one is not presented too
+		for _, c := range resigned[:len(certs)] {
+			s.Chain = append(s.Chain, c.Cert)
+		}
+	default:
+		return nil, errors.Errorf("unknown replay mode %d", mode)
+	}
+
+	s.cert = tls.Certificate{PrivateKey: s.Leaf.Key, OCSPStaple: r.OCSPStaple, Leaf: s.Chain[0]}
+	for _, cert := range s.Chain {
+		s.cert.Certificate = append(s.cert.Certificate, cert.Raw)
+	}
+	s.setPins()
+	return s, nil
+}
+
+// resign re-signs the chain by the generated keys from the top.
+// If the top cert is not self-signed the generated root is appended.
+func resign(certs []*x509.Certificate) ([]*minica.Cert, error) {
+	var root *minica.Cert
+	top := certs[len(certs)-1]
+	if !bytes.Equal(top.RawIssuer, top.RawSubject) || top.CheckSignature(top.SignatureAlgorithm, top.RawTBSCertificate, top.Signature) != nil {
+		key, err := keyLike(top.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		// the root with the name and the key ID the top cert refers to
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			RawSubject:            top.RawIssuer,
+			SubjectKeyId:          top.AuthorityKeyId,
+			NotBefore:             top.NotBefore.Add(-24 * time.Hour),
+			NotAfter:              top.NotAfter.Add(10 * 365 * 24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		}
+		if root, err = sign(tmpl, nil, key); err != nil {
+			return nil, errors.Wrap(err, "failed generate root")
+		}
+	}
+
+	resigned := make([]*minica.Cert, len(certs))
+	issuer := root
+	for i := len(certs) - 1; i >= 0; i-- {
+		key, err := keyLike(certs[i].PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		tmpl := *certs[i]
+		// chosen by the key of the issuer
+		tmpl.SignatureAlgorithm = x509.UnknownSignatureAlgorithm
+		// the Extensions of the template are ignored
+		tmpl.ExtraExtensions = unknownExtensions(certs[i].Extensions)
+		if resigned[i], err = sign(&tmpl, issuer, key); err != nil {
+			return nil, errors.Wrapf(err, "failed re-sign cert %d", i)
+		}
+		issuer = resigned[i]
+	}
+	if root != nil {
+		resigned = append(resigned, root)
+	}
+	return resigned, nil
+}
+
+// builtExtensions are the extensions x509.CreateCertificate builds from the fields of the template.
+var builtExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14},              // subject key identifier
+	{2, 5, 29, 35},              // authority key identifier
+	{2, 5, 29, 15},              // key usage
+	{2, 5, 29, 37},              // extended key usage
+	{2, 5, 29, 19},              // basic constraints
+	{2, 5, 29, 17},              // subject alternative name
+	{2, 5, 29, 30},              // name constraints
+	{2, 5, 29, 31},              // CRL distribution points
+	{2, 5, 29, 32},              // certificate policies
+	{1, 3, 6, 1, 5, 5, 7, 1, 1}, // authority information access
+}
+
+// unknownExtensions returns the extensions that are not built from the fields of the template.
+func unknownExtensions(exts []pkix.Extension) []pkix.Extension {
+	var unknown []pkix.Extension
+	for _, ext := range exts {
+		built := false
+		for _, id := range builtExtensions {
+			if ext.Id.Equal(id) {
+				built = true
+				break
+			}
+		}
+		if !built {
+			unknown = append(unknown, ext)
+		}
+	}
+	return unknown
+}
+
+// sign signs the template by the parent (self-signed if parent is nil).
+func sign(tmpl *x509.Certificate, parent *minica.Cert, key crypto.Signer) (*minica.Cert, error) {
+	// the public key of the recorded cert is replaced
+	tmpl.PublicKey = key.Public()
+	signer, signerCert := key, tmpl
+	if parent != nil {
+		signer, signerCert = parent.Key, parent.Cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &minica.Cert{Cert: cert, Key: key}, nil
+}
+
+// keyLike generates the key of the same type and size as the public key.
+func keyLike(pub crypto.PublicKey) (crypto.Signer, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		key, err = rsa.GenerateKey(rand.Reader, pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		key, err = ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case ed25519.PublicKey:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, errors.Errorf("unsupported public key %T", pub)
+	}
+	return key, errors.Wrap(err, "failed generate key")
+}
@@ -0,0 +1,126 @@
+package verifytest
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gebv/go-lib/tls/minica"
+	"github.com/gebv/go-lib/tls/verify"
+	"github.com/gebv/go-lib/tls/verify/recording"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordReplay(t *testing.T) {
+	partner := NewServer(t, TrustedOK)
+	partner.cert.OCSPStaple = []byte("staple")
+	// restart with the staple
+	partner.Close()
+	partner.serveTLS(t)
+
+	rec, err := recording.Record(context.Background(), partner.Addr, ServerName)
+	require.NoError(t, err)
+	assert.Equal(t, ServerName, rec.ServerName)
+	assert.Equal(t, []byte("staple"), rec.OCSPStaple)
+	require.Len(t, rec.Chain, 2)
+
+	file := filepath.Join(t.TempDir(), "partner.json")
+	require.NoError(t, rec.WriteFile(file))
+	rec, err = recording.Load(file)
+	require.NoError(t, err)
+	certs, err := rec.Certificates()
+	require.NoError(t, err)
+	for i, cert := range partner.Chain {
+		assert.True(t, cert.Equal(certs[i]))
+	}
+
+	t.Run("exact", func(t *testing.T) {
+		s := NewReplayServer(t, rec, ReplayExact)
+		assert.Equal(t, partner.FingerprintSHA1, s.FingerprintSHA1)
+		assert.Equal(t, partner.PinSHA256, s.PinSHA256)
+
+		d, err := verify.NewDialer(verify.SkipTLSVerify(), verify.PinSHA256(partner.PinSHA256))
+		require.NoError(t, err)
+		_, err = d.DialContext(context.Background(), "tcp", s.Addr)
+		require.Error(t, err, "the server does not own the key")
+		assert.False(t, errors.Is(err, verify.ErrNotMatchedPin), "the pin should pass, got %v", err)
+
+		d, err = verify.NewDialer(verify.SkipTLSVerify(), verify.PinSHA256(NewServer(t, TrustedOK).PinSHA256))
+		require.NoError(t, err)
+		_, err = d.DialContext(context.Background(), "tcp", s.Addr)
+		assert.ErrorIs(t, err, verify.ErrNotMatchedPin)
+
+		// or the verifier gets the chain directly
+		raw, err := rec.RawChain()
+		require.NoError(t, err)
+		v := verify.TLSVerifyPeerCertificate(verify.SkipTLSVerify(), verify.FingerprintSHA1(partner.FingerprintSHA1))
+		assert.NoError(t, v.Option()(raw, nil))
+	})
+	t.Run("resigned", func(t *testing.T) {
+		s := NewReplayHTTPServer(t, rec, ReplayResigned, nil)
+		require.Len(t, s.Chain, 2)
+		assert.NotEqual(t, partner.PinSHA256, s.PinSHA256)
+		for i, cert := range s.Chain {
+			assert.Equal(t, certs[i].Subject.String(), cert.Subject.String())
+			assert.Equal(t, certs[i].Issuer.String(), cert.Issuer.String())
+			assert.Equal(t, certs[i].SerialNumber, cert.SerialNumber)
+			assert.Equal(t, certs[i].DNSNames, cert.DNSNames)
+			assert.Equal(t, certs[i].NotAfter, cert.NotAfter)
+		}
+		assert.Equal(t, partner.Root.Cert.Subject.String(), s.Root.Cert.Subject.String())
+		assert.NotNil(t, s.Intermediate)
+
+		c := verify.HttpClient(verify.RootCAs(s.Roots))
+		res, err := c.Get(s.URL)
+		require.NoError(t, err)
+		dat, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(dat))
+
+		_, err = verify.HttpClient(verify.RootCAs(partner.Roots)).Get(s.URL)
+		assert.Error(t, err, "the original root should not trust the re-signed chain")
+		_, err = http.Get(s.URL)
+		assert.Error(t, err)
+	})
+	t.Run("resignedSelfSigned", func(t *testing.T) {
+		selfSigned := NewServer(t, SelfSigned)
+		rec, err := recording.Record(context.Background(), selfSigned.Addr, "")
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1", rec.ServerName)
+		s := NewReplayServer(t, rec, ReplayResigned)
+		require.Len(t, s.Chain, 1)
+		assert.True(t, s.Root.Cert.Equal(s.Chain[0]))
+	})
+	t.Run("resignedExtensions", func(t *testing.T) {
+		mustStaple := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}, Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05}}
+		leaf, err := partner.Intermediate.IssueServer(minica.Request{
+			Hosts:     []string{ServerName},
+			NotBefore: time.Now().Add(-time.Hour),
+		})
+		require.NoError(t, err)
+		tmpl := *leaf.Cert
+		tmpl.ExtraExtensions = []pkix.Extension{mustStaple}
+		withExt, err := sign(&tmpl, partner.Intermediate, leaf.Key)
+		require.NoError(t, err)
+
+		resigned, err := resign([]*x509.Certificate{withExt.Cert, partner.Intermediate.Cert})
+		require.NoError(t, err)
+		assert.Contains(t, resigned[0].Cert.Extensions, mustStaple)
+		assert.Equal(t, len(withExt.Cert.Extensions), len(resigned[0].Cert.Extensions))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := replay(&recording.Recording{}, ReplayExact)
+		assert.Error(t, err)
+		_, err = replay(&recording.Recording{Chain: []string{"not PEM"}}, ReplayExact)
+		assert.Error(t, err)
+	})
+}
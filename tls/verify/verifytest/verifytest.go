@@ -0,0 +1,298 @@
+// Package verifytest provides the in-process TLS servers with the generated
+// certs for the tests of the verification of the peer certs.
+//
+// Every server has its own root and intermediate CAs, the scenario defines
+// what is wrong with the chain the server presents. The server returns the
+// roots a correctly configured client trusts and the pins of the presented leaf.
+//
+// The replay servers present the chains recorded from the real endpoints
+// (see recording.Record, NewReplayServer and the tlsrecord command).
+package verifytest
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gebv/go-lib/tls/minica"
+	"github.com/pkg/errors"
+)
+
+// Scenario is what the presented chain is like.
+type Scenario string
+
+const (
+	// TrustedOK presents the valid leaf for ServerName and 127.0.0.1 with the intermediate.
+	TrustedOK Scenario = "trusted-ok"
+	// Expired presents the leaf expired a day ago.
+	Expired Scenario = "expired"
+	// NotYetValid presents the leaf valid from tomorrow.
+	NotYetValid Scenario = "not-yet-valid"
+	// SelfSigned presents the self-signed leaf, it is not issued by the roots.
+	SelfSigned Scenario = "self-signed"
+	// WrongHost presents the leaf for WrongServerName only.
+	WrongHost Scenario = "wrong-host"
+	// MissingIntermediate presents the leaf without the intermediate.
+	MissingIntermediate Scenario = "missing-intermediate"
+	// SHA1Signature presents the leaf signed with ECDSA-SHA1.
+	SHA1Signature Scenario = "sha1-signature"
+	// WeakKey presents the leaf with the 1024 bits RSA key.
+	WeakKey Scenario = "weak-key"
+	// ClientCertRequired presents the valid chain and requires the client cert
+	// issued by the client CA (see Server.ClientCert).
+	ClientCertRequired Scenario = "client-cert-required"
+)
+
+// Scenarios are all the scenarios.
+var Scenarios = []Scenario{
+	TrustedOK,
+	Expired,
+	NotYetValid,
+	SelfSigned,
+	WrongHost,
+	MissingIntermediate,
+	SHA1Signature,
+	WeakKey,
+	ClientCertRequired,
+}
+
+const (
+	// ServerName is the name the leaf is issued for (with 127.0.0.1 and ::1).
+	ServerName = "localhost"
+	// WrongServerName is the only name of the leaf of WrongHost.
+	WrongServerName = "wrong.example.com"
+)
+
+// Server is the TLS server of the scenario.
+type Server struct {
+	// Scenario is empty for the replay servers.
+	Scenario Scenario
+	// Addr is the address of the server, 127.0.0.1:port.
+	Addr string
+	// URL is the base URL of the HTTP server (https://127.0.0.1:port), it is empty for the TLS server.
+	URL string
+
+	// Roots trusts the root CA of the scenario (it does not trust the self-signed leaf).
+	Roots *x509.CertPool
+	// Root, Intermediate and Leaf are the generated certs of the scenario,
+	// the leaf of SelfSigned is not issued by the intermediate.
+	Root, Intermediate, Leaf *minica.Cert
+	// Chain is the presented chain, leaf first.
+	Chain []*x509.Certificate
+
+	// FingerprintSHA1 is the hex SHA-1 fingerprint of the leaf.
+	FingerprintSHA1 string
+	// PinSHA256 is the SPKI pin of the leaf in the form "sha256/<base64>".
+	PinSHA256 string
+
+	// ClientCAs trusts the client CA of ClientCertRequired, it is nil for the other scenarios.
+	ClientCAs *x509.CertPool
+	// ClientCert is the client cert issued by the client CA of ClientCertRequired.
+	ClientCert tls.Certificate
+
+	cert tls.Certificate
+	lis  net.Listener
+	http *httptest.Server
+}
+
+// NewServer starts the TLS server of the scenario that writes "ok" to every connection.
+// The server is closed at the end of the test.
+func NewServer(tb testing.TB, scenario Scenario) *Server {
+	tb.Helper()
+
+	s := newServer(tb, scenario)
+	s.serveTLS(tb)
+	return s
+}
+
+// NewCerts issues the certs of the scenario without the server, Addr and URL are empty.
+// The certs are served by the TLSConfig, e.g. by the gRPC server.
+func NewCerts(tb testing.TB, scenario Scenario) *Server {
+	tb.Helper()
+	return newServer(tb, scenario)
+}
+
+// NewHTTPServer starts the HTTPS server (with HTTP/2) of the scenario.
+// If handler is nil the server responds "ok".
+// The server is closed at the end of the test.
+func NewHTTPServer(tb testing.TB, scenario Scenario, handler http.Handler) *Server {
+	tb.Helper()
+
+	s := newServer(tb, scenario)
+	s.serveHTTP(tb, handler)
+	return s
+}
+
+func (s *Server) serveTLS(tb testing.TB) {
+	tb.Helper()
+
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", s.TLSConfig())
+	if err != nil {
+		tb.Fatal("failed listen:", err)
+	}
+	s.lis = lis
+	s.Addr = lis.Addr().String()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("ok"))
+			}()
+		}
+	}()
+	tb.Cleanup(s.Close)
+}
+
+func (s *Server) serveHTTP(tb testing.TB, handler http.Handler) {
+	if handler == nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+	}
+	s.http = httptest.NewUnstartedServer(handler)
+	s.http.EnableHTTP2 = true
+	// the failed handshakes are expected
+	s.http.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.http.TLS = s.TLSConfig()
+	s.http.StartTLS()
+	s.Addr = s.http.Listener.Addr().String()
+	s.URL = s.http.URL
+	tb.Cleanup(s.Close)
+}
+
+func newServer(tb testing.TB, scenario Scenario) *Server {
+	tb.Helper()
+
+	s, err := generate(scenario)
+	if err != nil {
+		tb.Fatalf("failed generate certs of scenario %q: %v", scenario, err)
+	}
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	if s.http != nil {
+		s.http.Close()
+	}
+	if s.lis != nil {
+		s.lis.Close()
+	}
+}
+
+// TLSConfig returns the server TLS config with the presented chain of the scenario.
+func (s *Server) TLSConfig() *tls.Config {
+	cfg := &tls.Config{Certificates: []tls.Certificate{s.cert}}
+	if s.ClientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = s.ClientCAs
+	}
+	return cfg
+}
+
+// generate issues the certs of the scenario.
+func generate(scenario Scenario) (*Server, error) {
+	s := &Server{Scenario: scenario}
+
+	var err error
+	if s.Root, err = minica.NewRoot(caRequest("verifytest root")); err != nil {
+		return nil, err
+	}
+	if s.Intermediate, err = s.Root.NewIntermediate(caRequest("verifytest intermediate")); err != nil {
+		return nil, err
+	}
+	s.Roots = x509.NewCertPool()
+	s.Roots.AddCert(s.Root.Cert)
+
+	req := minica.Request{
+		Subject:   pkix.Name{Organization: []string{"verifytest"}},
+		Hosts:     []string{ServerName, "127.0.0.1", "::1"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(24 * time.Hour),
+	}
+	issuer, chain := s.Intermediate, []*minica.Cert{s.Intermediate}
+	switch scenario {
+	case TrustedOK, ClientCertRequired:
+	case Expired:
+		req.NotBefore = time.Now().Add(-48 * time.Hour)
+		req.NotAfter = time.Now().Add(-24 * time.Hour)
+	case NotYetValid:
+		req.NotBefore = time.Now().Add(24 * time.Hour)
+		req.NotAfter = time.Now().Add(48 * time.Hour)
+	case SelfSigned:
+		issuer, chain = nil, nil
+	case WrongHost:
+		req.Hosts = []string{WrongServerName}
+	case MissingIntermediate:
+		chain = nil
+	case SHA1Signature:
+		req.SignatureAlgorithm = x509.ECDSAWithSHA1
+	case WeakKey:
+		req.KeyType = minica.RSA1024
+	default:
+		return nil, errors.Errorf("unknown scenario %q", scenario)
+	}
+	if issuer == nil {
+		s.Leaf, err = minica.SelfSigned(req)
+	} else {
+		s.Leaf, err = issuer.IssueServer(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.cert = s.Leaf.TLSCertificate(chain...)
+	s.Chain = []*x509.Certificate{s.Leaf.Cert}
+	for _, cert := range chain {
+		s.Chain = append(s.Chain, cert.Cert)
+	}
+
+	s.setPins()
+
+	if scenario == ClientCertRequired {
+		clientCA, err := minica.NewRoot(caRequest("verifytest client CA"))
+		if err != nil {
+			return nil, err
+		}
+		client, err := clientCA.IssueClient(minica.Request{
+			Subject:   pkix.Name{Organization: []string{"verifytest"}, CommonName: "verifytest client"},
+			NotBefore: time.Now().Add(-time.Hour),
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.ClientCAs = x509.NewCertPool()
+		s.ClientCAs.AddCert(clientCA.Cert)
+		s.ClientCert = client.TLSCertificate()
+	}
+	return s, nil
+}
+
+// setPins sets the pins of the presented leaf.
+func (s *Server) setPins() {
+	sha1Sum := sha1.Sum(s.Chain[0].Raw)
+	s.FingerprintSHA1 = hex.EncodeToString(sha1Sum[:])
+	pin := sha256.Sum256(s.Chain[0].RawSubjectPublicKeyInfo)
+	s.PinSHA256 = "sha256/" + base64.StdEncoding.EncodeToString(pin[:])
+}
+
+func caRequest(name string) minica.Request {
+	return minica.Request{
+		Subject:   pkix.Name{Organization: []string{"verifytest"}, CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour),
+	}
+}
@@ -0,0 +1,125 @@
+package verifytest
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServer(t *testing.T) {
+	cases := []struct {
+		scenario Scenario
+		// wantErr is the error of the handshake by the client trusting the roots
+		wantErr string
+		// anyErr is set if the result depends on the Go version
+		anyErr bool
+	}{
+		{TrustedOK, "", false},
+		{Expired, "certificate has expired or is not yet valid", false},
+		{NotYetValid, "certificate has expired or is not yet valid", false},
+		{SelfSigned, "certificate signed by unknown authority", false},
+		{WrongHost, "certificate is valid for wrong.example.com, not localhost", false},
+		{MissingIntermediate, "certificate signed by unknown authority", false},
+		// SHA-1 signatures are rejected since Go 1.18
+		{SHA1Signature, "", true},
+		{WeakKey, "", false},
+		{ClientCertRequired, "", false},
+	}
+	require.Len(t, cases, len(Scenarios))
+	for _, c := range cases {
+		c := c
+		t.Run(string(c.scenario), func(t *testing.T) {
+			s := NewServer(t, c.scenario)
+			cfg := &tls.Config{RootCAs: s.Roots, ServerName: ServerName}
+			if s.ClientCAs != nil {
+				cfg.Certificates = []tls.Certificate{s.ClientCert}
+			}
+			conn, err := tls.Dial("tcp", s.Addr, cfg)
+			if c.anyErr {
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			if c.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+			dat, err := ioutil.ReadAll(conn)
+			require.NoError(t, err)
+			assert.Equal(t, "ok", string(dat))
+
+			state := conn.ConnectionState()
+			require.Len(t, state.PeerCertificates, len(s.Chain))
+			for i, cert := range s.Chain {
+				assert.True(t, cert.Equal(state.PeerCertificates[i]))
+			}
+		})
+	}
+
+	t.Run("pins", func(t *testing.T) {
+		s := NewServer(t, SelfSigned)
+		assert.Len(t, s.FingerprintSHA1, 40)
+		assert.Regexp(t, `^sha256/[A-Za-z0-9+/]{43}=$`, s.PinSHA256)
+		assert.Equal(t, s.Leaf.Cert.Subject.String(), s.Leaf.Cert.Issuer.String())
+		assert.Len(t, s.Chain, 1)
+	})
+	t.Run("sha1Signature", func(t *testing.T) {
+		s := NewServer(t, SHA1Signature)
+		assert.Equal(t, x509.ECDSAWithSHA1, s.Leaf.Cert.SignatureAlgorithm)
+	})
+	t.Run("weakKey", func(t *testing.T) {
+		s := NewServer(t, WeakKey)
+		key, ok := s.Leaf.Cert.PublicKey.(*rsa.PublicKey)
+		require.True(t, ok)
+		assert.Equal(t, 1024, key.N.BitLen())
+	})
+	t.Run("noClientCert", func(t *testing.T) {
+		s := NewServer(t, ClientCertRequired)
+		conn, err := tls.Dial("tcp", s.Addr, &tls.Config{RootCAs: s.Roots, ServerName: ServerName})
+		if err == nil {
+			// the server rejects the client cert after the client handshake in TLS 1.3
+			_, err = ioutil.ReadAll(conn)
+			conn.Close()
+		}
+		assert.Error(t, err)
+	})
+}
+
+func TestNewCerts(t *testing.T) {
+	s := NewCerts(t, ClientCertRequired)
+	assert.Empty(t, s.Addr)
+	cfg := s.TLSConfig()
+	require.Len(t, cfg.Certificates, 1)
+	assert.Len(t, cfg.Certificates[0].Certificate, len(s.Chain))
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	assert.Same(t, s.ClientCAs, cfg.ClientCAs)
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	s := NewHTTPServer(t, TrustedOK, nil)
+	c := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: s.Roots},
+		ForceAttemptHTTP2: true,
+	}}
+	res, err := c.Get(s.URL)
+	require.NoError(t, err)
+	dat, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(dat))
+	assert.Equal(t, 2, res.ProtoMajor)
+
+	_, err = http.Get(s.URL)
+	var unknownAuthority x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknownAuthority)
+}
@@ -2,51 +2,82 @@ package verify
 
 import (
 	"context"
-	"crypto"
 	"crypto/x509"
+	"sync/atomic"
 	"time"
 
 	internalErrors "github.com/gebv/go-lib/internal/errors"
-	"github.com/pion/dtls/v2/pkg/crypto/fingerprint"
 	"github.com/pkg/errors"
 )
 
 var (
 	ErrCertExpired           = errors.New("certificate expired")
 	ErrNotMatchedFingerprint = errors.New("not matched fingerprint")
+	ErrNotMatchedPin         = errors.New("not matched public key pin")
+	ErrNotMatchedIdentity    = errors.New("not matched identity")
 )
 
-func TLSVerifyPeerCertificate(opts ...tlsVerifyPeerCertificateOption) *tlsVerifyPeerCertificate {
+// TLSVerifyPeerCertificate returns the verifier of the server certs.
+// The options are not validated here, the invalid ones (e.g. the malformed pin) fail
+// each verification.
+//
+// Deprecated: use NewTLSVerifyPeerCertificate, it rejects the invalid options.
+func TLSVerifyPeerCertificate(opts ...Option) *tlsVerifyPeerCertificate {
 	v := &tlsVerifyPeerCertificate{
-		opts:    &tlsVerifyPeerCertificateOptions{},
 		waitErr: internalErrors.WaitOneErrorOrNil(),
 	}
-	for _, set := range opts {
-		set(v.opts)
-	}
+	v.opts.Store(newOptions(opts...))
 	return v
 }
 
-func TLSVerifyPeerCertificateWithContext(ctx context.Context, opts ...tlsVerifyPeerCertificateOption) (*tlsVerifyPeerCertificate, context.Context) {
+// NewTLSVerifyPeerCertificate returns the verifier or the error of the invalid options,
+// e.g. the malformed fingerprint or pin.
+func NewTLSVerifyPeerCertificate(opts ...Option) (*tlsVerifyPeerCertificate, error) {
+	return newVerifier(opts...)
+}
+
+func TLSVerifyPeerCertificateWithContext(ctx context.Context, opts ...Option) (*tlsVerifyPeerCertificate, context.Context) {
 	w, ctx := internalErrors.WaitOneErrorOrNilWithontext(ctx)
 	v := &tlsVerifyPeerCertificate{
-		opts:    &tlsVerifyPeerCertificateOptions{},
 		waitErr: w,
 	}
-	for _, set := range opts {
-		set(v.opts)
-	}
+	v.opts.Store(newOptions(opts...))
 	return v, ctx
 }
 
 type tlsVerifyPeerCertificate struct {
-	opts    *tlsVerifyPeerCertificateOptions
+	opts    atomic.Value // *Options
 	waitErr interface {
 		Wait() error
 		Release(err error)
 	}
 }
 
+func newOptions(opts ...Option) *Options {
+	o := &Options{}
+	for _, set := range opts {
+		set(o)
+	}
+	return o
+}
+
+// options returns the current options. The options should not be changed.
+func (v *tlsVerifyPeerCertificate) options() *Options {
+	return v.opts.Load().(*Options)
+}
+
+// Update replaces the options of the verifier by the new ones.
+// It is safe to call concurrently with the handshakes,
+// the handshakes started after the update are verified by the new options.
+func (v *tlsVerifyPeerCertificate) Update(opts ...Option) error {
+	o := newOptions(opts...)
+	if err := o.validate(); err != nil {
+		return err
+	}
+	v.opts.Store(o)
+	return nil
+}
+
 func (v *tlsVerifyPeerCertificate) Wait() error {
 	if v.waitErr == nil {
 		return errors.New("error waiter in nil")
@@ -58,65 +89,127 @@ func (v *tlsVerifyPeerCertificate) Option() func(rawCerts [][]byte, verifiedChai
 	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 		defer v.releaseDone()
 
-		opts := x509.VerifyOptions{
-			// TODO: add rootCAs
-			CurrentTime:   time.Now(),
-			DNSName:       v.opts.DNSName,
-			Intermediates: x509.NewCertPool(),
+		if _, err := v.verify(context.Background(), v.options(), rawCerts); err != nil {
+			v.releaseError(err)
+			return err
 		}
+		return nil
+	}
+}
 
-		// Coped code from https://github.com/golang/go/blob/1419ca7cead4438c8c9f17d8901aeecd9c72f577/src/crypto/tls/handshake_client.go#L835
-		certs := make([]*x509.Certificate, len(rawCerts))
-		for i, asn1Data := range rawCerts {
-			cert, err := x509.ParseCertificate(asn1Data)
-			if err != nil {
-				err = errors.Wrap(err, "failed to parse certificate from server")
-				v.releaseError(err)
-				return err
-			}
-			certs[i] = cert
+// verify checks the presented chain against the options and notifies the observers.
+// The result is not nil if the presented certs are parsed.
+// In the report-only mode the failed checks are kept in the Result.Violations
+// and nil error is returned.
+func (v *tlsVerifyPeerCertificate) verify(ctx context.Context, opts *Options, rawCerts [][]byte) (*Result, error) {
+	res, err := v.check(ctx, opts, rawCerts)
+
+	var chain *CapturedChain
+	if opts.CaptureChain && (err != nil || (res != nil && len(res.Violations) > 0)) {
+		chain = newCapturedChain(rawCerts)
+	}
+	if err != nil {
+		err = newVerificationError(err, opts, res, chain)
+	}
+	if res != nil {
+		for i, violation := range res.Violations {
+			res.Violations[i] = newVerificationError(violation, opts, res, chain)
 		}
+	}
 
-		if !v.opts.SkipTLSVerify {
-			for _, cert := range certs[1:] {
-				opts.Intermediates.AddCert(cert)
-			}
-			_, err := certs[0].Verify(opts)
-			certErr := x509.CertificateInvalidError{}
-			if errors.As(err, &certErr) {
-				switch certErr.Reason {
-				case x509.Expired:
-					err = ErrCertExpired
-					v.releaseError(err)
-					return err
-				default:
-					// not supported reason error
-					v.releaseError(err)
-					return err
-				}
-			} else if err != nil {
-				// failed TLS verify cert
-				v.releaseError(err)
-				return err
-			}
+	if len(opts.Observers) > 0 {
+		e := Event{
+			Addr:       addrFromContext(ctx),
+			ServerName: opts.DNSName,
+			KnownPins:  opts.knownPins(),
+			Result:     res,
+			Err:        err,
+			Chain:      chain,
 		}
+		for _, observe := range opts.Observers {
+			observe(e)
+		}
+	}
+	return res, err
+}
 
-		if len(v.opts.SHA1Fingerprint) > 0 {
-			gotFingerprint, err := fingerprint.Fingerprint(certs[0], crypto.SHA1)
-			if err != nil {
-				err = errors.Wrap(err, "failed to create a fingerprint for server cert")
-				v.releaseError(err)
-				return err
-			}
-			if normalHex(v.opts.SHA1Fingerprint) != normalHex(gotFingerprint) {
-				err := ErrNotMatchedFingerprint
-				v.releaseError(err)
-				return err
+func (v *tlsVerifyPeerCertificate) check(ctx context.Context, opts *Options, rawCerts [][]byte) (*Result, error) {
+	// Coped code from https://github.com/golang/go/blob/1419ca7cead4438c8c9f17d8901aeecd9c72f577/src/crypto/tls/handshake_client.go#L835
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, asn1Data := range rawCerts {
+		cert, err := x509.ParseCertificate(asn1Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse certificate from server")
+		}
+		certs[i] = cert
+	}
+	if len(certs) == 0 {
+		if opts.clientCerts {
+			return nil, errors.New("client did not provide a certificate")
+		}
+		return nil, errors.New("server did not provide a certificate")
+	}
+	res := newResult(certs)
+
+	checks, err := opts.checks()
+	if err != nil {
+		return res, err
+	}
+	chain := &Chain{
+		Certificates: certs,
+		ServerName:   opts.DNSName,
+		Roots:        opts.RootCAs,
+	}
+	if opts.clientCerts {
+		chain.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	for _, check := range checks {
+		state := chain.save()
+		if err := check.Check(ctx, chain); err != nil {
+			chain.restore(state)
+			// in the report-only mode the failed check is kept and the rest are run
+			if !opts.ReportOnly {
+				// the failed verification has no verified chains and passed checks
+				return res, err
 			}
+			res.Violations = append(res.Violations, err)
 		}
+	}
+	res.VerifiedChains = chain.VerifiedChains
+	res.SPIFFEID = chain.SPIFFEID
+	res.Checks = chain.passed
+	return res, nil
+}
 
-		return nil
+// verifyChain builds the chain from the leaf to the roots (the system roots if nil)
+// using the rest of the presented certs as intermediates. The dnsName is checked if set.
+// The leaf is verified for the server auth if keyUsages are not set.
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, dnsName string, keyUsages ...x509.ExtKeyUsage) ([][]*x509.Certificate, error) {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		CurrentTime:   time.Now(),
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     keyUsages,
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(opts)
+	certErr := x509.CertificateInvalidError{}
+	if errors.As(err, &certErr) {
+		switch certErr.Reason {
+		case x509.Expired:
+			return nil, &VerificationError{Err: ErrCertExpired, Cert: certErr.Cert}
+		default:
+			// not supported reason error
+			return nil, err
+		}
+	} else if err != nil {
+		// failed TLS verify cert
+		return nil, err
 	}
+	return chains, nil
 }
 
 func (v *tlsVerifyPeerCertificate) releaseError(err error) {